@@ -2,19 +2,31 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"slices"
 	"time"
 
 	"github.com/mikerhodes/github-to-omnifocus/internal"
 	"github.com/mikerhodes/github-to-omnifocus/internal/delta"
 	"github.com/mikerhodes/github-to-omnifocus/internal/gh"
+	"github.com/mikerhodes/github-to-omnifocus/internal/guard"
 	"github.com/mikerhodes/github-to-omnifocus/internal/omnifocus"
+	"github.com/mikerhodes/github-to-omnifocus/internal/plan"
+	"github.com/mikerhodes/github-to-omnifocus/internal/schedule"
+	"github.com/mikerhodes/github-to-omnifocus/internal/state"
 )
 
 // Version can be overridden at build time using PROJECT_VERSION in the makefile.
 var Version = "development"
 
+// exitGuardTripped is the exit status used when a run is stopped because
+// it would complete more tasks than the configured limits allow.
+const exitGuardTripped = 3
+
 type OFCurrentState struct {
 	Issues        []omnifocus.Task
 	PRs           []omnifocus.Task
@@ -31,6 +43,18 @@ func main() {
 	log.Printf("[main] Starting github2omnifocus; version: %s.", Version)
 
 	configPathOverride := flag.String("config", "", "Path to the config file")
+	force := flag.Bool("force", false, "Complete tasks even if more would be completed than the configured limits allow")
+	reportDuplicates := flag.Bool("report-duplicates", false, "List duplicate tasks for the same GitHub item, without changing Omnifocus")
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage: %s [flags] [command]\n\n", os.Args[0])
+		fmt.Fprintf(w, "Commands:\n")
+		fmt.Fprintf(w, "  (none)        sync GitHub to Omnifocus\n")
+		fmt.Fprintf(w, "  plan [-out f] write the changes a sync would make to a plan file\n")
+		fmt.Fprintf(w, "  apply <plan>  make the changes in a plan file\n\n")
+		fmt.Fprintf(w, "Flags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	c, err := internal.LoadConfig(*configPathOverride)
@@ -38,174 +62,465 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// The due date we use is "end of today"
-	dueDate := time.Now().Local()
-	dueDate = time.Date(
-		dueDate.Year(),
-		dueDate.Month(),
-		dueDate.Day(),
-		23,
-		59,
-		59,
-		0,
-		dueDate.Location())
+	loc, err := c.Location()
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Now().In(loc)
 
 	// Gateways are used to access Omnifocus and GitHub
 	og := omnifocus.Gateway{
-		AppTag:                  c.AppTag,
-		AssignedTag:             c.AssignedTag,
-		AssignedProject:         c.AssignedProject,
-		ReviewTag:               c.ReviewTag,
-		ReviewProject:           c.ReviewProject,
-		NotificationTag:         c.NotificationTag,
-		NotificationsProject:    c.NotificationsProject,
-		SetNotificationsDueDate: c.SetNotificationsDueDate,
-		DueDate:                 dueDate,
+		Runner:               omnifocus.OsascriptRunner{Path: c.OsascriptPath},
+		AppTag:               c.AppTag,
+		AssignedTag:          c.AssignedTag,
+		AssignedProject:      c.AssignedProject,
+		ReviewTag:            c.ReviewTag,
+		ReviewProject:        c.ReviewProject,
+		NotificationTag:      c.NotificationTag,
+		NotificationsProject: c.NotificationsProject,
+		DueDates:             map[omnifocus.Kind]time.Time{},
+		DeferDates:           map[omnifocus.Kind]time.Time{},
+		Flagged:              map[omnifocus.Kind]bool{},
+		Inbox:                map[omnifocus.Kind]bool{},
+		Anywhere:             map[omnifocus.Kind]bool{},
+		ReopenWithin:         map[omnifocus.Kind]time.Duration{},
 	}
-	ghg, err := gh.NewGitHubGateway(context.Background(), c.AccessToken, c.APIURL)
+	for _, k := range omnifocus.Kinds {
+		cc := c.Category(string(k))
+		due, err := schedule.Parse(cc.DueDate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		deferDate, err := schedule.Parse(cc.DeferDate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		og.DueDates[k] = due.Date(now, schedule.EndOfDay)
+		og.DeferDates[k] = deferDate.Date(now, schedule.StartOfDay)
+		og.Flagged[k] = cc.Flag
+		og.Inbox[k] = cc.Inbox
+		og.Anywhere[k] = cc.FindAnywhere
+		og.ReopenWithin[k] = time.Duration(cc.ReopenWithinDays) * 24 * time.Hour
+	}
+
+	limits := map[omnifocus.Kind]guard.Limit{}
+	for _, k := range omnifocus.Kinds {
+		limits[k] = toLimit(c.Category(string(k)))
+	}
+
+	args := flag.Args()
+
+	// Only a sync or apply changes Omnifocus, so plan and
+	// --report-duplicates leave missing projects to be created later.
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+	create := (command == "" && !*reportDuplicates) || command == "apply"
+	missing, err := setUpProjects(&og, c, create)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(args) == 0 {
+		p, current, err := createPlan(c, og)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *reportDuplicates {
+			logDuplicates(p)
+			return
+		}
+		if !checkLimits(p, current, limits, *force) {
+			os.Exit(exitGuardTripped)
+		}
+		applyPlan(og, p, c.StatePath)
+		return
+	}
+
+	switch args[0] {
+	case "plan":
+		fs := flag.NewFlagSet("plan", flag.ExitOnError)
+		out := fs.String("out", "github2omnifocus.plan.json", "Path to write the plan to")
+		_ = fs.Parse(args[1:])
+
+		p, current, err := createPlan(c, og)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p.MissingProjects = missing
+		for _, project := range p.MissingProjects {
+			log.Printf("Plan: create project %s", project)
+		}
+		for _, op := range p.Operations {
+			log.Printf("Plan: %s", op)
+		}
+		if !checkLimits(p, current, limits, *force) {
+			log.Printf("Applying this plan will fail unless --force is used.")
+		}
+		err = p.Save(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Saved plan with %d operations to %s. Apply it using: %s apply %s",
+			len(p.Operations), *out, os.Args[0], *out)
+	case "apply":
+		if len(args) != 2 { //nolint:gomnd
+			log.Fatalf("Usage: %s apply <plan>", os.Args[0])
+		}
+		p, err := plan.Load(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		current, err := og.GetTasks()
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = p.Check(current)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !checkLimits(p, current, limits, *force) {
+			os.Exit(exitGuardTripped)
+		}
+		applyPlan(og, p, c.StatePath)
+	default:
+		flag.Usage()
+		os.Exit(2) //nolint:gomnd
+	}
+}
+
+// setUpProjects looks up the projects used for tasks, so the whole run
+// uses the same ones. If the config asks for missing projects to be
+// created, they're created when create is true; otherwise, they're
+// returned so they can be reported without changing Omnifocus.
+func setUpProjects(og *omnifocus.Gateway, c internal.Config, create bool) ([]string, error) {
+	if c.CreateMissingProjects && create {
+		err := og.EnsureProjects()
+		if err != nil {
+			return nil, err
+		}
+	}
+	missing, err := og.ResolveProjects(c.CreateMissingProjects && !create)
+	if errors.Is(err, omnifocus.ErrProjectNotFound) {
+		return nil, fmt.Errorf("%w; create it in Omnifocus, or set CreateMissingProjects in the config", err)
+	}
+	return missing, err
+}
+
+// createPlan retrieves the current state from Omnifocus and the desired
+// state from GitHub, and returns a plan of the operations that will bring
+// Omnifocus into line with GitHub, along with the current tasks.
+func createPlan(c internal.Config, og omnifocus.Gateway) (plan.Plan, []omnifocus.Task, error) {
+	ghg, err := gh.NewGitHubGateway(context.Background(), c.AccessToken, c.APIURL)
+	if err != nil {
+		return plan.Plan{}, nil, err
+	}
+	ghg.UseGraphQL = c.GitHubAPI == "graphql"
 
 	// Retrieve our current (from Omnifocus) and desired (from GitHub) states
 	currentState, err := GetOFState(og)
 	if err != nil {
-		log.Fatal(err)
+		return plan.Plan{}, nil, err
 	}
-	desiredState, err := GetGitHubState(ghg)
+	prev, err := state.Load(c.StatePath)
 	if err != nil {
-		log.Fatal(err)
+		return plan.Plan{}, nil, err
+	}
+	desiredState, poll, err := GetGitHubState(ghg, prev.Notifications)
+	if err != nil {
+		return plan.Plan{}, nil, err
 	}
+	ghg.LogQuota()
 
 	log.Printf("Current state: %d issues; %d PRs; %d notifications.", len(currentState.Issues), len(currentState.PRs), len(currentState.Notifications))
 	log.Printf("Desired state: %d issues; %d PRs; %d notifications.", len(desiredState.Issues), len(desiredState.PRs), len(desiredState.Notifications))
 
-	// Create the delta and apply it to Omnifocus.
-	// The awful looking *(d.Item.(*gh.GitHubItem)) casts are hacks, that we
-	// know to be true because we know how Delta works. I suspect this is a
-	// thing that generics will make easier as we can better smuggle the
-	// types through Delta rather than using the interface.
-
-	d := delta.Delta(toSetGH(desiredState.Issues), toSetOF(currentState.Issues))
-	log.Printf("Found %d changes to apply to Issues", len(d))
-	for _, d := range d {
-		if d.Type == delta.Add {
-			err := og.AddIssue(*(d.Item.(*gh.GitHubItem)))
-			if err != nil {
-				// should never fail
-				log.Fatal(err)
-			}
-		} else if d.Type == delta.Remove {
-			err := og.CompleteIssue(*(d.Item.(*omnifocus.Task)))
-			if err != nil {
-				// should never fail
-				log.Fatal(err)
-			}
+	// The delta is across all kinds of task, so that an item that changes
+	// kind, such as a PR moving from review requested to assigned, has its
+	// task moved rather than completed and re-created.
+	desired := og.Desired(desiredState.Issues, desiredState.PRs, desiredState.Notifications)
+	current := slices.Concat(currentState.Issues, currentState.PRs, currentState.Notifications)
+	omnifocus.SortOldestFirst(current)
+	withKnownHTMLURLs(og, desired, current, prev)
+	all := desired
+
+	// Give the user a chance to deal with new items before creating tasks
+	// for them. Synced and tombstoned items are passed through, so a task
+	// the user completed isn't re-created.
+	desired = omnifocus.HoldYoung(desired, current, prev.Synced, prev.Tombstones, func(k omnifocus.Kind) time.Duration {
+		return time.Duration(c.Category(string(k)).MinAgeMinutes) * time.Minute
+	}, time.Now())
+
+	// Leave out items whose tasks the user has completed in Omnifocus, so
+	// we don't re-add them until they change on GitHub.
+	desired, next := state.Tombstone(prev, desired, current, tombstoneGrace(c), time.Now())
+	next.Notifications = poll
+
+	d := delta.Delta(desired, current)
+
+	// Only complete tasks whose items have been gone for long enough.
+	d.Remove = state.ApplyGrace(prev, &next, d.Remove, func(t omnifocus.Task) state.Grace {
+		return toGrace(c.Category(string(t.Kind)))
+	}, time.Now())
+
+	log.Printf("Found %d changes: %d add; %d complete; %d update; %d move; %d duplicate.",
+		d.Len(), len(d.Add), len(d.Remove), len(d.Update), len(d.Move), len(d.Duplicate))
+
+	// Reopen recently completed tasks rather than adding new ones, keeping
+	// the user's notes and history together.
+	ops := omnifocus.Operations(d)
+	setDispositions(ops, c, ghg)
+	if len(d.Add) > 0 {
+		completed, err := og.GetCompletedTasks(time.Now())
+		if err != nil {
+			return plan.Plan{}, nil, err
 		}
+		ops = omnifocus.ReopenCompleted(ops, completed)
 	}
 
-	d = delta.Delta(toSetGH(desiredState.PRs), toSetOF(currentState.PRs))
-	log.Printf("Found %d changes to apply to PRs", len(d))
-	for _, d := range d {
-		if d.Type == delta.Add {
-			err := og.AddPR(*(d.Item.(*gh.GitHubItem)))
-			if err != nil {
-				// should never fail
-				log.Fatal(err)
-			}
-		} else if d.Type == delta.Remove {
-			err := og.CompletePR(*(d.Item.(*omnifocus.Task)))
-			if err != nil {
-				// should never fail
-				log.Fatal(err)
-			}
+	next.HTMLURLs, err = resolveHTMLURLs(ghg, og, ops, all)
+	if err != nil {
+		return plan.Plan{}, nil, err
+	}
+
+	return plan.New(ops, current, next), current, nil
+}
+
+// withKnownHTMLURLs fills in the HTML URLs of the notifications in desired
+// that we already know, so they can be compared with their tasks without
+// asking GitHub. A URL is taken from the cache in prev, or else, if the
+// item hasn't changed since the last run, from its existing task in
+// current.
+func withKnownHTMLURLs(og omnifocus.Gateway, desired []omnifocus.DesiredTask, current []omnifocus.Task, prev state.State) {
+	urls := map[string]string{}
+	for _, t := range current {
+		if _, ok := urls[t.Key()]; !ok && t.URL() != "" {
+			urls[t.Key()] = t.URL()
+		}
+	}
+	for i, d := range desired {
+		item := d.Item
+		if item.HTMLURL != "" || item.HTMLURLSource == "" {
+			continue
+		}
+		if u, ok := prev.HTMLURLs[item.HTMLURLSource]; ok {
+			item.HTMLURL = u
+		} else if u, ok := urls[d.Key()]; ok && prev.Synced[d.Key()] == d.SyncFingerprint() {
+			item.HTMLURL = u
+		} else {
+			continue
+		}
+		desired[i] = og.WithItem(d, item)
+	}
+}
+
+// resolveHTMLURLs asks GitHub for the HTML URLs of the notifications in ops
+// whose URLs aren't yet known. These are the notifications we add or
+// reopen tasks for, along with those that have a new comment, so the
+// notifications that need no change cost nothing. It returns the cache to
+// save for the next run, holding the URLs of the items in all.
+func resolveHTMLURLs(ghg gh.GitHubGateway, og omnifocus.Gateway, ops []omnifocus.Operation, all []omnifocus.DesiredTask) (map[string]string, error) {
+	indexes := []int{}
+	items := []gh.GitHubItem{}
+	for i, op := range ops {
+		if op.Desired == nil || op.Desired.Item.HTMLURL != "" || op.Desired.Item.HTMLURLSource == "" {
+			continue
 		}
+		indexes = append(indexes, i)
+		items = append(items, op.Desired.Item)
+	}
+	resolved := map[string]string{}
+	if len(items) > 0 {
+		err := ghg.ResolveHTMLURLs(items, resolved)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for j, i := range indexes {
+		d := og.WithItem(*ops[i].Desired, items[j])
+		ops[i].Desired = &d
 	}
 
-	d = delta.Delta(toSetGH(desiredState.Notifications), toSetOF(currentState.Notifications))
-	log.Printf("Found %d changes to apply to Notifications", len(d))
-	for _, d := range d {
-		if d.Type == delta.Add {
-			err := og.AddNotification(*(d.Item.(*gh.GitHubItem)))
+	used := map[string]string{}
+	for _, d := range all {
+		source := d.Item.HTMLURLSource
+		if source == "" {
+			continue
+		}
+		if u, ok := resolved[source]; ok {
+			used[source] = u
+		} else if d.Item.HTMLURL != "" {
+			used[source] = d.Item.HTMLURL
+		}
+	}
+	return used, nil
+}
+
+// setDispositions decides what is done with the task of each remove
+// operation in ops, according to its category's OnRemove setting. For
+// "auto", GitHub is asked why the item went away: tasks for items the user
+// acted on, by closing or merging them, closing them with their own commit
+// or PR, or reviewing them, are completed, and the rest are dropped. If
+// that can't be worked out, the task is completed.
+func setDispositions(ops []omnifocus.Operation, c internal.Config, ghg gh.GitHubGateway) {
+	for i, op := range ops {
+		if op.Type != delta.Remove {
+			continue
+		}
+		switch c.Category(string(op.Task.Kind)).OnRemove {
+		case "drop":
+			ops[i].Disposition = omnifocus.DispositionDrop
+		case "delete":
+			ops[i].Disposition = omnifocus.DispositionDelete
+		case "auto":
+			reason, err := ghg.WhyGone(op.Task.Key(), op.Task.Kind == omnifocus.KindReview)
 			if err != nil {
-				// should never fail
-				log.Fatal(err)
+				log.Printf("Can't tell why %s went away, completing it: %v", op.Task.Key(), err)
 			}
-		} else if d.Type == delta.Remove {
-			err := og.CompleteNotification(*(d.Item.(*omnifocus.Task)))
-			if err != nil {
-				// should never fail
-				log.Fatal(err)
+			log.Printf("%s went away: %s", op.Task.Key(), reason)
+			switch reason {
+			case gh.GoneOpen, gh.GoneClosed:
+				ops[i].Disposition = omnifocus.DispositionDrop
+			default:
+				ops[i].Disposition = omnifocus.DispositionComplete
 			}
+		default:
+			ops[i].Disposition = omnifocus.DispositionComplete
+		}
+	}
+}
+
+// logDuplicates lists the duplicate tasks that p would complete.
+func logDuplicates(p plan.Plan) {
+	n := 0
+	for _, op := range p.Operations {
+		if op.Type == delta.Duplicate {
+			log.Printf("Duplicate: %s", op.Task)
+			n++
+		}
+	}
+	log.Printf("Found %d duplicate tasks.", n)
+}
+
+// checkLimits returns false if p would complete more of the tasks in
+// current than limits allow, logging the operations it refuses to carry
+// out. When force is true, the violations are logged but true is returned.
+func checkLimits(p plan.Plan, current []omnifocus.Task, limits map[omnifocus.Kind]guard.Limit, force bool) bool {
+	vs := guard.Check(p.Operations, current, limits)
+	for _, v := range vs {
+		if force {
+			log.Printf("Ignoring limit due to --force: %v", v)
+			continue
+		}
+		log.Printf("%v", v)
+		for _, op := range v.Removals {
+			log.Printf("  Refused: %s", op)
 		}
 	}
+	return force || len(vs) == 0
 }
 
-// toSetGH creates a delta.Keyed set from a slice of GitHubItem
-func toSetGH(l []gh.GitHubItem) map[delta.Keyed]struct{} {
-	r := map[delta.Keyed]struct{}{}
-	for _, i := range l {
-		// need to clone because range reuses `i` for each item!
-		r[&gh.GitHubItem{
-			Title:   i.Title,
-			HTMLURL: i.HTMLURL,
-			APIURL:  i.APIURL,
-			K:       i.K,
-		}] = struct{}{}
-	}
-	return r
+func toLimit(cc internal.CategoryConfig) guard.Limit {
+	return guard.Limit{
+		MaxRemove:        cc.MaxRemove,
+		MaxRemovePercent: cc.MaxRemovePercent,
+	}
 }
 
-// toSetOF creates a delta.Keyed set from a slice of OmnifocusTask
-func toSetOF(l []omnifocus.Task) map[delta.Keyed]struct{} {
-	r := map[delta.Keyed]struct{}{}
-	for _, i := range l {
-		// need to clone because range reuses `i` for each item!
-		r[&omnifocus.Task{
-			ID:   i.ID,
-			Name: i.Name,
-		}] = struct{}{}
-	}
-	return r
+func toGrace(cc internal.CategoryConfig) state.Grace {
+	return state.Grace{
+		Runs:   cc.GraceRuns,
+		Period: time.Duration(cc.GraceMinutes) * time.Minute,
+	}
+}
+
+// tombstoneGrace returns how long the tombstone of an item missing from
+// GitHub is kept: at least state.TombstoneGrace, and no less than the grace
+// of any category, as we don't know which category a missing item was in.
+func tombstoneGrace(c internal.Config) state.Grace {
+	g := state.TombstoneGrace
+	for _, k := range omnifocus.Kinds {
+		cc := c.Category(string(k))
+		g.Runs = max(g.Runs, cc.GraceRuns)
+		g.Period = max(g.Period, time.Duration(cc.GraceMinutes)*time.Minute)
+	}
+	return g
 }
 
-// GetGitHubState retrieves the current state of our item types from GitHub
-func GetGitHubState(ghg gh.GitHubGateway) (GHDesiredState, error) {
+// applyPlan carries out the operations in p, then saves its state to
+// statePath. An operation that fails is logged and the rest are still
+// carried out, unless Omnifocus can't be used at all. If any operation
+// failed, applyPlan exits with a non-zero status once the state is saved.
+func applyPlan(og omnifocus.Gateway, p plan.Plan, statePath string) {
+	results, err := og.ApplyAll(p.Operations)
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed := 0
+	for _, r := range results {
+		if errors.Is(r.Err, omnifocus.ErrAppNotRunning) || errors.Is(r.Err, omnifocus.ErrPermissionDenied) {
+			log.Fatal(r.Err)
+		}
+		if r.Err != nil {
+			log.Printf("Failed: %s: %v", r.Operation, r.Err)
+			failed++
+			if (r.Operation.Type == delta.Add || r.Operation.Type == delta.Reopen) && r.Operation.Desired != nil {
+				// The item has no task, so mustn't be remembered as synced
+				// or next run would take it to have been completed by the
+				// user.
+				delete(p.State.Synced, r.Operation.Desired.Key())
+			}
+		}
+	}
+	err = p.State.Save(statePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d operations failed", failed, len(p.Operations))
+	}
+}
+
+// GetGitHubState retrieves the current state of our item types from
+// GitHub. Notifications are only retrieved if they may have changed since
+// poll, the last poll of them; the new poll is returned.
+func GetGitHubState(ghg gh.GitHubGateway, poll gh.NotificationPoll) (GHDesiredState, gh.NotificationPoll, error) {
 	ghState := GHDesiredState{}
 	var err error
 
 	ghState.Issues, err = ghg.GetIssues()
 	if err != nil {
-		return GHDesiredState{}, err
+		return GHDesiredState{}, poll, err
 	}
 	ghState.PRs, err = ghg.GetPRs()
 	if err != nil {
-		return GHDesiredState{}, err
+		return GHDesiredState{}, poll, err
 	}
-	ghState.Notifications, err = ghg.GetNotifications()
+	ghState.Notifications, poll, err = ghg.PollNotifications(poll, time.Now())
 	if err != nil {
-		return GHDesiredState{}, err
+		return GHDesiredState{}, poll, err
 	}
 
-	return ghState, nil
+	return ghState, poll, nil
 }
 
 // GetOFState retrieves the current state of our item types from Omnifocus
 func GetOFState(og omnifocus.Gateway) (OFCurrentState, error) {
 	ofState := OFCurrentState{}
-	var err error
 
-	ofState.Issues, err = og.GetIssues()
+	tasks, err := og.GetTasks()
 	if err != nil {
 		return OFCurrentState{}, err
 	}
-	ofState.PRs, err = og.GetPRs()
-	if err != nil {
-		return OFCurrentState{}, err
-	}
-	ofState.Notifications, err = og.GetNotifications()
-	if err != nil {
-		return OFCurrentState{}, err
+	for _, t := range tasks {
+		switch t.Kind {
+		case omnifocus.KindAssigned:
+			ofState.Issues = append(ofState.Issues, t)
+		case omnifocus.KindReview:
+			ofState.PRs = append(ofState.PRs, t)
+		case omnifocus.KindNotification:
+			ofState.Notifications = append(ofState.Notifications, t)
+		}
 	}
 
 	return ofState, nil
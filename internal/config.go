@@ -6,6 +6,10 @@ import (
 	"log"
 	"os"
 	"path"
+	"strings"
+	"time"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/schedule"
 )
 
 type Config struct {
@@ -13,9 +17,14 @@ type Config struct {
 	APIURL string
 	// Personal Access token
 	AccessToken string
+	// Which GitHub API to fetch issues and PRs with: "rest" (the default)
+	// or "graphql"
+	GitHubAPI string
 	// OF Tag applied to every task managed by the app (so we never mess with other tasks)
 	AppTag string
-	// OF Project that assigned issues are added to
+	// OF Project that assigned issues are added to. Projects are given by
+	// name, or as a Folder/Subfolder/Project path; a name containing "/"
+	// is tried as a name first.
 	AssignedProject string
 	// OF Tag for assigned items
 	AssignedTag string
@@ -27,8 +36,85 @@ type Config struct {
 	NotificationsProject string
 	// OF Tag for notifications
 	NotificationTag string
-	// True if due date of today should be set on notifications
+	// True if due date of today should be set on notifications; only used
+	// if Notifications.DueDate isn't set
 	SetNotificationsDueDate bool
+	// IANA name of the timezone that due and defer dates are worked out
+	// in, such as "Europe/London"; defaults to the system's
+	Timezone string
+	// True if the projects, and any folders in their paths, should be
+	// created when they don't exist
+	CreateMissingProjects bool
+	// Path to the osascript binary used to talk to Omnifocus
+	OsascriptPath string
+	// Path to the file where state is kept between runs; defaults to
+	// the config file's path with a .state.json extension
+	StatePath string
+	// Per-category settings for assigned issues, PRs for review and
+	// notifications
+	Assigned      CategoryConfig
+	Review        CategoryConfig
+	Notifications CategoryConfig
+}
+
+// CategoryConfig holds the settings for a single category of task.
+type CategoryConfig struct {
+	// Abort the run if more than this many tasks would be completed (0 for no limit)
+	MaxRemove int
+	// Abort the run if more than this percentage of tasks would be completed (0 for no limit)
+	MaxRemovePercent float64
+	// Only complete a task once its item has been missing from GitHub for
+	// this many consecutive runs or minutes, whichever comes first (0 for
+	// straight away)
+	GraceRuns    int
+	GraceMinutes int
+	// Only create a task once its item was last updated on GitHub at least
+	// this many minutes ago (0 for straight away)
+	MinAgeMinutes int
+	// Create tasks in the inbox, assigned to the category's project, rather
+	// than in the project itself
+	Inbox bool
+	// Find tasks by their tags wherever they are, rather than only within
+	// the category's project
+	FindAnywhere bool
+	// Reopen a task completed up to this many days ago, rather than adding
+	// a new one, when its item needs a task again (0 to always add)
+	ReopenWithinDays int
+	// Due and defer dates given to new tasks: "none", "today", a time of
+	// day such as "17:00", or "+N business days", optionally followed by
+	// " at HH:MM"
+	DueDate   string
+	DeferDate string
+	// Flag new tasks
+	Flag bool
+	// What to do with a task whose item has gone from GitHub: "complete"
+	// (the default), "drop", "delete", or "auto" to complete it if the
+	// user acted on the item, such as by reviewing, closing or merging it,
+	// and drop it otherwise
+	OnRemove string
+}
+
+// Category returns the settings for the category of task with the given
+// kind, which is one of "assigned", "review" or "notification".
+func (c Config) Category(kind string) CategoryConfig {
+	switch kind {
+	case "assigned":
+		return c.Assigned
+	case "review":
+		return c.Review
+	case "notification":
+		return c.Notifications
+	}
+	return CategoryConfig{}
+}
+
+// Location returns the timezone that due and defer dates are worked out
+// in.
+func (c Config) Location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.Timezone)
 }
 
 // LoadConfig loads JSON config from ~/.config/github2omnifocus/config.json
@@ -60,14 +146,53 @@ func LoadConfig(configPathOverride string) (Config, error) {
 		NotificationsProject:    "GitHub Notifications",
 		NotificationTag:         "notification",
 		SetNotificationsDueDate: true,
+		OsascriptPath:           "/usr/bin/osascript",
 	}
 	err = json.Unmarshal(bytes, &c)
 	if err != nil {
 		return Config{}, fmt.Errorf("error unmarshalling config JSON from %s: %v", configPath, err)
 	}
 
+	switch c.GitHubAPI {
+	case "", "rest", "graphql":
+	default:
+		return Config{}, fmt.Errorf("unknown GitHubAPI in %s: %q; expected rest or graphql", configPath, c.GitHubAPI)
+	}
+
+	if c.Notifications.DueDate == "" && c.SetNotificationsDueDate {
+		c.Notifications.DueDate = "today"
+	}
+
+	for name, cc := range map[string]CategoryConfig{
+		"Assigned":      c.Assigned,
+		"Review":        c.Review,
+		"Notifications": c.Notifications,
+	} {
+		switch cc.OnRemove {
+		case "", "complete", "drop", "delete", "auto":
+		default:
+			return Config{}, fmt.Errorf("unknown %s.OnRemove in %s: %q", name, configPath, cc.OnRemove)
+		}
+		if _, err := schedule.Parse(cc.DueDate); err != nil {
+			return Config{}, fmt.Errorf("bad %s.DueDate in %s: %v", name, configPath, err)
+		}
+		if _, err := schedule.Parse(cc.DeferDate); err != nil {
+			return Config{}, fmt.Errorf("bad %s.DeferDate in %s: %v", name, configPath, err)
+		}
+	}
+	if _, err := c.Location(); err != nil {
+		return Config{}, fmt.Errorf("bad Timezone in %s: %v", configPath, err)
+	}
+
+	if c.StatePath == "" {
+		c.StatePath = strings.TrimSuffix(configPath, path.Ext(configPath)) + ".state.json"
+	}
+
 	log.Printf("Config loaded from %s:", configPath)
 	log.Printf("  GitHub API server: %s", c.APIURL)
+	if c.GitHubAPI == "graphql" {
+		log.Printf("  GitHub API: GraphQL")
+	}
 	if c.AccessToken != "" {
 		log.Printf("  GitHub token: *****")
 	} else {
@@ -77,6 +202,49 @@ func LoadConfig(configPathOverride string) (Config, error) {
 	log.Printf("  Omnifocus assigned issue project: %s", c.AssignedProject)
 	log.Printf("  Omnifocus PR to review project: %s", c.ReviewProject)
 	log.Printf("  Omnifocus notifications project: %s", c.NotificationsProject)
+	if c.CreateMissingProjects {
+		log.Printf("  Missing Omnifocus projects will be created")
+	}
+	if c.Timezone != "" {
+		log.Printf("  Timezone for dates: %s", c.Timezone)
+	}
+	log.Printf("  State file: %s", c.StatePath)
+	logCategory("assigned", c.Assigned)
+	logCategory("review", c.Review)
+	logCategory("notifications", c.Notifications)
 
 	return c, nil
 }
+
+func logCategory(name string, cc CategoryConfig) {
+	if cc.MaxRemove > 0 || cc.MaxRemovePercent > 0 {
+		log.Printf("  Max %s tasks to complete per run: %d tasks or %.0f%% (0 is unlimited)", name, cc.MaxRemove, cc.MaxRemovePercent)
+	}
+	if cc.GraceRuns > 0 || cc.GraceMinutes > 0 {
+		log.Printf("  Complete %s tasks once missing for: %d runs or %d minutes (0 is unused)", name, cc.GraceRuns, cc.GraceMinutes)
+	}
+	if cc.MinAgeMinutes > 0 {
+		log.Printf("  Create %s tasks once unchanged for: %d minutes", name, cc.MinAgeMinutes)
+	}
+	if cc.Inbox {
+		log.Printf("  New %s tasks are created in the inbox", name)
+	}
+	if cc.FindAnywhere {
+		log.Printf("  Find %s tasks in any project", name)
+	}
+	if cc.ReopenWithinDays > 0 {
+		log.Printf("  Reopen %s tasks completed in the last: %d days", name, cc.ReopenWithinDays)
+	}
+	if cc.DueDate != "" && cc.DueDate != "none" {
+		log.Printf("  New %s tasks are due: %s", name, cc.DueDate)
+	}
+	if cc.DeferDate != "" && cc.DeferDate != "none" {
+		log.Printf("  New %s tasks are deferred until: %s", name, cc.DeferDate)
+	}
+	if cc.Flag {
+		log.Printf("  New %s tasks are flagged", name)
+	}
+	if cc.OnRemove != "" && cc.OnRemove != "complete" {
+		log.Printf("  Removed %s tasks are: %s", name, cc.OnRemove)
+	}
+}
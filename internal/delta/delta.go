@@ -1,6 +1,7 @@
 // Package delta provides functions to create "deltas" between two sets, which
-// consist of add and remove operations to make a second set contain the same
-// items as the first set.
+// consist of add, remove, update and move operations to make a second set
+// contain the same items as the first set. Deltas also report duplicate items
+// in the second set, which should be removed.
 //
 // Within github2omnifocus, this is used to create the operations that bring the
 // task list state in the local tool, Omnifocus, into line with the desired
@@ -11,75 +12,196 @@ import (
 	"fmt"
 )
 
-// OperationType states whether a DeltaOperation is add or remove.
+// OperationType states whether a DeltaOperation is add, remove, update,
+// move or duplicate.
 type OperationType int
 
 const (
 	Add OperationType = iota + 1
 	Remove
+	Update
+	Move
+	Duplicate
+	// Reopen is never returned by Delta. Callers use it for an add that is
+	// carried out by reopening an item that was removed earlier.
+	Reopen
 )
 
 func (op OperationType) String() string {
-	ops := [...]string{"add", "remove"}
-	if op < Add || op > Remove {
+	ops := [...]string{"add", "remove", "update", "move", "duplicate", "reopen"}
+	if op < Add || op > Reopen {
 		return fmt.Sprintf("DeltaOperation(%d)", int(op))
 	}
 	return ops[op-1]
 }
 
+// MarshalText allows an OperationType to be stored as its name, for
+// example in JSON.
+func (op OperationType) MarshalText() ([]byte, error) {
+	if op < Add || op > Reopen {
+		return nil, fmt.Errorf("unknown operation type: %d", int(op))
+	}
+	return []byte(op.String()), nil
+}
+
+// UnmarshalText reads an OperationType stored using MarshalText.
+func (op *OperationType) UnmarshalText(text []byte) error {
+	for o := Add; o <= Reopen; o++ {
+		if o.String() == string(text) {
+			*op = o
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown operation type: %s", text)
+}
+
 // Keyed provides the Key function which is used by the Delta function to
 // identify items uniquely.
 type Keyed interface {
 	Key() string
 }
 
-// A Operation states that Item should be added or removed from a set.
-type Operation struct {
-	Item Keyed
-	Type OperationType
+// Fingerprinted is optionally implemented by items passed to Delta. When
+// both the desired and current item for a key implement it, and their
+// fingerprints differ, Delta reports an update for the key.
+type Fingerprinted interface {
+	Fingerprint() string
 }
 
-// Delta returns a slice of DeltaOperations that, when applied to current,
-// will result in current containing the same items as desired.
-func Delta(desired, current map[Keyed]struct{}) []Operation {
-	ops := []Operation{}
+// Categorised is optionally implemented by items passed to Delta. When both
+// the desired and current item for a key implement it, and their categories
+// differ, Delta reports a move for the key.
+type Categorised interface {
+	Category() string
+}
+
+// Match pairs a desired item with the current item that has the same key.
+type Match[D, C Keyed] struct {
+	Desired D
+	Current C
+}
+
+// Result holds the outcome of Delta. Add holds desired items missing from
+// current, Remove holds current items missing from desired, Move holds
+// items present in both whose categories differ, Update holds items present
+// in both whose fingerprints differ, and Keep holds the remaining items
+// present in both. Duplicate holds the current items that have the same key
+// as an earlier item in current.
+type Result[D, C Keyed] struct {
+	Add       []D
+	Remove    []C
+	Update    []Match[D, C]
+	Move      []Match[D, C]
+	Keep      []Match[D, C]
+	Duplicate []C
+}
+
+// Len returns the number of operations in r that require a change to be
+// made, that is, the adds, removes, updates, moves and duplicates.
+func (r Result[D, C]) Len() int {
+	return len(r.Add) + len(r.Remove) + len(r.Update) + len(r.Move) + len(r.Duplicate)
+}
 
-	// Flipping to use the Key() as the map's hashkey allows for quicker lookup.
+// Delta returns a Result that, when its operations are applied to current,
+// will result in current containing the same items as desired. Items are
+// matched using their Key, and compared using their Category and
+// Fingerprint if they are Categorised or Fingerprinted. When more than one
+// current item has the same key, the first is used and the rest are
+// reported as duplicates, so callers should order current by preference.
+// Each slice in the Result is in the order its items are in desired or
+// current.
+func Delta[D, C Keyed](desired []D, current []C) Result[D, C] {
+	r := Result[D, C]{
+		Add:       []D{},
+		Remove:    []C{},
+		Update:    []Match[D, C]{},
+		Move:      []Match[D, C]{},
+		Keep:      []Match[D, C]{},
+		Duplicate: []C{},
+	}
+
+	// Using the Key() as the map's hashkey allows for quicker lookup.
 	// Without doing this, we are forced to essentially do the comparison as
 	// a list comparison, looping over one list with an internal loop over the
 	// other list, calling Key() all the time. For notifications in particular,
 	// this can become large quickly: even a 50 item list ends up being in worst
 	// case 2 * 50^2 = 5000 comparisons and Key() calls.
-	desired2 := map[string]Keyed{}
-	for k := range desired {
-		desired2[k.Key()] = k
-		// log.Printf("Desired: %s", k.Key())
+	desired2 := map[string]D{}
+	for _, d := range desired {
+		desired2[d.Key()] = d
 	}
-	current2 := map[string]Keyed{}
-	for k := range current {
-		current2[k.Key()] = k
-		// log.Printf("Current: %s", k.Key())
+	current2 := map[string]C{}
+	for _, c := range current {
+		if _, ok := current2[c.Key()]; ok {
+			r.Duplicate = append(r.Duplicate, c)
+			continue
+		}
+		current2[c.Key()] = c
 	}
 
-	// If it's in desired, and not in current: add it.
-	for k, v := range desired2 {
-		if _, ok := current2[k]; !ok {
-			ops = append(ops, Operation{
-				Type: Add,
-				Item: v,
-			})
+	// If it's in desired, and not in current: add it. If it's in both,
+	// move it if its category has changed, update it if it has otherwise
+	// changed, or else keep it. We loop over the slices rather than the
+	// maps so that the operations come out in the order of their items.
+	done := map[string]bool{}
+	for _, d := range desired {
+		k := d.Key()
+		if done[k] {
+			continue
+		}
+		done[k] = true
+		d = desired2[k]
+		c, ok := current2[k]
+		if !ok {
+			r.Add = append(r.Add, d)
+			continue
+		}
+		m := Match[D, C]{Desired: d, Current: c}
+		if moved(d, c) {
+			r.Move = append(r.Move, m)
+		} else if changed(d, c) {
+			r.Update = append(r.Update, m)
+		} else {
+			r.Keep = append(r.Keep, m)
 		}
 	}
 
 	// If it's in current, and not in desired: remove it.
-	for k, v := range current2 {
-		if _, ok := desired2[k]; !ok {
-			ops = append(ops, Operation{
-				Type: Remove,
-				Item: v,
-			})
+	for _, c := range current {
+		k := c.Key()
+		if _, ok := desired2[k]; !ok && !done[k] {
+			done[k] = true
+			r.Remove = append(r.Remove, current2[k])
 		}
 	}
 
-	return ops
+	return r
+}
+
+// changed returns true if d and c are both Fingerprinted and their
+// fingerprints differ.
+func changed(d, c any) bool {
+	df, ok := d.(Fingerprinted)
+	if !ok {
+		return false
+	}
+	cf, ok := c.(Fingerprinted)
+	if !ok {
+		return false
+	}
+	return df.Fingerprint() != cf.Fingerprint()
+}
+
+// moved returns true if d and c are both Categorised and their categories
+// differ.
+func moved(d, c any) bool {
+	dc, ok := d.(Categorised)
+	if !ok {
+		return false
+	}
+	cc, ok := c.(Categorised)
+	if !ok {
+		return false
+	}
+	return dc.Category() != cc.Category()
 }
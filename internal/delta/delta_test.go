@@ -9,117 +9,276 @@ type MockKeyed struct {
 	key string
 }
 
-func (mk *MockKeyed) Key() string {
+func (mk MockKeyed) Key() string {
 	return mk.key
 }
 
+// MockCurrent is a different type to MockKeyed so we check Delta copes
+// with desired and current items having different types.
+type MockCurrent struct {
+	id  string
+	key string
+}
+
+func (mc MockCurrent) Key() string {
+	return mc.key
+}
+
+func sortKeyed[K Keyed](items []K) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Key() < items[j].Key()
+	})
+}
+
 func TestDelta1NoChange(t *testing.T) {
-	current := map[Keyed]struct{}{
-		&MockKeyed{key: "foo"}: {},
-		&MockKeyed{key: "bar"}: {},
+	current := []MockCurrent{
+		{key: "foo"},
+		{key: "bar"},
 	}
-	desired := map[Keyed]struct{}{
-		&MockKeyed{key: "foo"}: {},
-		&MockKeyed{key: "bar"}: {},
+	desired := []MockKeyed{
+		{key: "foo"},
+		{key: "bar"},
 	}
-	ops := Delta(desired, current)
-	if len(ops) != 0 {
+	r := Delta(desired, current)
+	if r.Len() != 0 {
 		t.Fatal("Did not receive empty operations slice")
 	}
+	if len(r.Keep) != 2 {
+		t.Fatalf("Expected 2 kept items, got %d", len(r.Keep))
+	}
 }
 
 func TestDelta2AddItem(t *testing.T) {
-	current := map[Keyed]struct{}{
-		&MockKeyed{key: "bar"}: {},
+	current := []MockCurrent{
+		{key: "bar"},
 	}
-	desired := map[Keyed]struct{}{
-		&MockKeyed{key: "foo"}: {},
-		&MockKeyed{key: "bar"}: {},
+	desired := []MockKeyed{
+		{key: "foo"},
+		{key: "bar"},
 	}
-	ops := Delta(desired, current)
-	if len(ops) != 1 {
+	r := Delta(desired, current)
+	if r.Len() != 1 {
 		t.Fatal("Expected 1 add operation")
 	}
-	if ops[0].Type != Add {
+	if len(r.Add) != 1 {
 		t.Fatal("Expected 1 add operation")
 	}
-	if ops[0].Item.Key() != "foo" {
+	if r.Add[0].Key() != "foo" {
 		t.Fatal("Expected 1 add operation")
 	}
 }
 
 func TestDelta3RemoveItem(t *testing.T) {
-	current := map[Keyed]struct{}{
-		&MockKeyed{key: "foo"}: {},
-		&MockKeyed{key: "bar"}: {},
+	current := []MockCurrent{
+		{id: "1", key: "foo"},
+		{id: "2", key: "bar"},
 	}
-	desired := map[Keyed]struct{}{
-		&MockKeyed{key: "foo"}: {},
+	desired := []MockKeyed{
+		{key: "foo"},
 	}
-	ops := Delta(desired, current)
-	if len(ops) != 1 {
+	r := Delta(desired, current)
+	if r.Len() != 1 {
 		t.Fatal("Expected 1 remove operation")
 	}
-	if ops[0].Type != Remove {
+	if len(r.Remove) != 1 {
 		t.Fatal("Expected 1 remove operation")
 	}
-	if ops[0].Item.Key() != "bar" {
+	if r.Remove[0].Key() != "bar" || r.Remove[0].id != "2" {
 		t.Fatal("Expected 1 remove operation")
 	}
 }
 
 func TestDelta4AllChange(t *testing.T) {
-	current := map[Keyed]struct{}{
-		&MockKeyed{key: "baz"}:  {},
-		&MockKeyed{key: "quux"}: {},
+	current := []MockCurrent{
+		{key: "baz"},
+		{key: "quux"},
 	}
-	desired := map[Keyed]struct{}{
-		&MockKeyed{key: "foo"}: {},
-		&MockKeyed{key: "bar"}: {},
+	desired := []MockKeyed{
+		{key: "foo"},
+		{key: "bar"},
 	}
-	ops := Delta(desired, current)
-	if len(ops) != 4 {
+	r := Delta(desired, current)
+	if r.Len() != 4 || len(r.Add) != 2 || len(r.Remove) != 2 {
 		t.Fatal("Expected 4 operations, 2 add, 2 remove")
 	}
 
-	sort.SliceStable(ops, func(i, j int) bool {
-		return ops[i].Item.Key() < ops[j].Item.Key()
-	})
+	sortKeyed(r.Add)
+	sortKeyed(r.Remove)
 
-	if ops[0].Type != Add {
+	if r.Add[0].Key() != "bar" || r.Add[1].Key() != "foo" {
 		t.Fatal("Expected 4 operations, 2 add, 2 remove")
 	}
-	if ops[0].Item.Key() != "bar" {
+	if r.Remove[0].Key() != "baz" || r.Remove[1].Key() != "quux" {
 		t.Fatal("Expected 4 operations, 2 add, 2 remove")
 	}
+}
 
-	if ops[1].Type != Remove {
-		t.Fatal("Expected 4 operations, 2 add, 2 remove")
+func TestDelta5KeepPairsItems(t *testing.T) {
+	current := []MockCurrent{
+		{id: "1", key: "foo"},
 	}
-	if ops[1].Item.Key() != "baz" {
-		t.Fatal("Expected 4 operations, 2 add, 2 remove")
+	desired := []MockKeyed{
+		{key: "foo"},
 	}
+	r := Delta(desired, current)
+	if len(r.Keep) != 1 {
+		t.Fatalf("Expected 1 kept item, got %d", len(r.Keep))
+	}
+	if r.Keep[0].Desired.Key() != "foo" || r.Keep[0].Current.id != "1" {
+		t.Fatalf("Kept item not paired correctly: %+v", r.Keep[0])
+	}
+}
 
-	if ops[2].Type != Add {
-		t.Fatal("Expected 4 operations, 2 add, 2 remove")
+func TestDeltaNoItems(t *testing.T) {
+	r := Delta([]MockKeyed{}, []MockCurrent{})
+	if r.Len() != 0 {
+		t.Fatal("Did not receive empty operations slice")
 	}
-	if ops[2].Item.Key() != "foo" {
-		t.Fatal("Expected 4 operations, 2 add, 2 remove")
+}
+
+type MockFingerprinted struct {
+	key string
+	fp  string
+}
+
+func (mf MockFingerprinted) Key() string {
+	return mf.key
+}
+
+func (mf MockFingerprinted) Fingerprint() string {
+	return mf.fp
+}
+
+func TestDelta6UpdateItem(t *testing.T) {
+	current := []MockFingerprinted{
+		{key: "foo", fp: "1"},
+		{key: "bar", fp: "1"},
+	}
+	desired := []MockFingerprinted{
+		{key: "foo", fp: "2"},
+		{key: "bar", fp: "1"},
 	}
+	r := Delta(desired, current)
+	if r.Len() != 1 || len(r.Update) != 1 {
+		t.Fatal("Expected 1 update operation")
+	}
+	if r.Update[0].Desired.fp != "2" || r.Update[0].Current.fp != "1" {
+		t.Fatalf("Update not paired correctly: %+v", r.Update[0])
+	}
+	if len(r.Keep) != 1 || r.Keep[0].Desired.Key() != "bar" {
+		t.Fatal("Expected bar to be kept")
+	}
+}
 
-	if ops[3].Type != Remove {
-		t.Fatal("Expected 4 operations, 2 add, 2 remove")
+func TestDelta7UpdateNeedsBothFingerprints(t *testing.T) {
+	current := []MockKeyed{
+		{key: "foo"},
 	}
-	if ops[3].Item.Key() != "quux" {
-		t.Fatal("Expected 4 operations, 2 add, 2 remove")
+	desired := []MockFingerprinted{
+		{key: "foo", fp: "2"},
+	}
+	r := Delta(desired, current)
+	if r.Len() != 0 {
+		t.Fatal("Expected no operations when current isn't Fingerprinted")
 	}
 }
 
-func TestDeltaNoItems(t *testing.T) {
-	current := map[Keyed]struct{}{}
-	desired := map[Keyed]struct{}{}
-	ops := Delta(desired, current)
-	if len(ops) != 0 {
-		t.Fatal("Did not receive empty operations slice")
+type MockCategorised struct {
+	key      string
+	category string
+	fp       string
+}
+
+func (mc MockCategorised) Key() string {
+	return mc.key
+}
+
+func (mc MockCategorised) Category() string {
+	return mc.category
+}
+
+func (mc MockCategorised) Fingerprint() string {
+	return mc.fp
+}
+
+func TestDelta8MoveItem(t *testing.T) {
+	current := []MockCategorised{
+		{key: "foo", category: "review", fp: "1"},
+		{key: "bar", category: "review", fp: "1"},
+	}
+	desired := []MockCategorised{
+		{key: "foo", category: "assigned", fp: "2"},
+		{key: "bar", category: "review", fp: "1"},
+	}
+	r := Delta(desired, current)
+	if r.Len() != 1 || len(r.Move) != 1 {
+		t.Fatalf("Expected 1 move operation, got %+v", r)
+	}
+	if r.Move[0].Desired.category != "assigned" || r.Move[0].Current.category != "review" {
+		t.Fatalf("Move not paired correctly: %+v", r.Move[0])
+	}
+	if len(r.Update) != 0 {
+		t.Fatal("Expected a move to take precedence over an update")
+	}
+}
+
+func TestOperationTypeText(t *testing.T) {
+	for _, op := range []OperationType{Add, Remove, Update, Move, Duplicate, Reopen} {
+		b, err := op.MarshalText()
+		if err != nil {
+			t.Fatalf("Unexpected error marshalling %s: %v", op, err)
+		}
+		var op2 OperationType
+		if err := op2.UnmarshalText(b); err != nil {
+			t.Fatalf("Unexpected error unmarshalling %s: %v", b, err)
+		}
+		if op2 != op {
+			t.Fatalf("Expected %s, got %s", op, op2)
+		}
+	}
+	var op OperationType
+	if err := op.UnmarshalText([]byte("frobnicate")); err == nil {
+		t.Fatal("Expected error unmarshalling unknown operation type")
+	}
+}
+
+func TestDelta9Duplicates(t *testing.T) {
+	current := []MockCurrent{
+		{id: "1", key: "foo"},
+		{id: "2", key: "foo"},
+		{id: "3", key: "bar"},
+		{id: "4", key: "foo"},
+	}
+	desired := []MockKeyed{
+		{key: "foo"},
+	}
+	r := Delta(desired, current)
+	if len(r.Duplicate) != 2 || r.Duplicate[0].id != "2" || r.Duplicate[1].id != "4" {
+		t.Fatalf("Expected later foo items to be duplicates, got %+v", r.Duplicate)
+	}
+	if len(r.Keep) != 1 || r.Keep[0].Current.id != "1" {
+		t.Fatalf("Expected first foo item to be kept, got %+v", r.Keep)
+	}
+	if len(r.Remove) != 1 || r.Remove[0].id != "3" {
+		t.Fatalf("Expected bar to be removed, got %+v", r.Remove)
+	}
+	if r.Len() != 3 {
+		t.Fatalf("Expected 3 operations, got %d", r.Len())
+	}
+}
+
+func TestDelta10KeepsOrder(t *testing.T) {
+	current := []MockCurrent{{key: "e"}, {key: "c"}, {key: "d"}, {key: "a"}}
+	desired := []MockKeyed{{key: "b"}, {key: "f"}, {key: "a"}}
+
+	// Maps are iterated in a random order, so try a few times.
+	for i := 0; i < 10; i++ {
+		r := Delta(desired, current)
+		if len(r.Add) != 2 || r.Add[0].key != "b" || r.Add[1].key != "f" {
+			t.Fatalf("Expected adds in desired's order, got %v", r.Add)
+		}
+		if len(r.Remove) != 3 || r.Remove[0].key != "e" || r.Remove[1].key != "c" || r.Remove[2].key != "d" {
+			t.Fatalf("Expected removes in current's order, got %v", r.Remove)
+		}
 	}
 }
@@ -9,7 +9,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-github/v41/github"
 	"golang.org/x/oauth2"
@@ -17,14 +20,26 @@ import (
 
 var paginationPerPage = 30
 
+// resolveConcurrency is the most requests ResolveHTMLURLs makes at once.
+const resolveConcurrency = 4
+
 // GitHubItem is a simple, unified structure we can use to represent issues,
 // PRs and notifications containing only the information the rest of the
-// program requires.
+// program requires. Notifications don't come with an HTMLURL; instead,
+// HTMLURLSource is the API URL of the comment or issue whose HTML URL it is,
+// and ResolveHTMLURLs fills it in.
 type GitHubItem struct {
-	Title   string
-	HTMLURL string
-	APIURL  string
-	K       string
+	Title         string    `json:"title"`
+	HTMLURL       string    `json:"htmlURL"`
+	HTMLURLSource string    `json:"htmlURLSource,omitempty"`
+	APIURL        string    `json:"apiURL"`
+	K             string    `json:"key"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	// Labels and Milestone are only set for issues and PRs. Draft is only
+	// set for PRs fetched with GraphQL, as the REST API doesn't say.
+	Labels    []string `json:"labels,omitempty"`
+	Milestone string   `json:"milestone,omitempty"`
+	Draft     bool     `json:"draft,omitempty"`
 }
 
 func (item GitHubItem) String() string {
@@ -39,8 +54,17 @@ func (item GitHubItem) Key() string {
 }
 
 type GitHubGateway struct {
+	// UseGraphQL fetches issues and PRs, and looks up notifications' HTML
+	// URLs, using GitHub's GraphQL API, which takes far fewer requests
+	// than the REST API. Notifications themselves are only available from
+	// the REST API.
+	UseGraphQL bool
+
 	ctx context.Context
 	c   *github.Client
+	rt  *retryTransport
+	// login is the authenticated user's login, once it has been retrieved.
+	login string
 }
 
 func NewGitHubGateway(ctx context.Context, accessToken, apiURL string) (GitHubGateway, error) {
@@ -48,6 +72,10 @@ func NewGitHubGateway(ctx context.Context, accessToken, apiURL string) (GitHubGa
 		&oauth2.Token{AccessToken: accessToken},
 	)
 	tc := oauth2.NewClient(ctx, ts)
+	// Ride out rate limits and transient errors rather than failing the
+	// run.
+	rt := newRetryTransport(tc.Transport)
+	tc.Transport = rt
 
 	// Passing APIURL as the uploadURL (2nd param) technically doesn't
 	// work but we never upload so we're okay
@@ -60,12 +88,22 @@ func NewGitHubGateway(ctx context.Context, accessToken, apiURL string) (GitHubGa
 	return GitHubGateway{
 		ctx: ctx,
 		c:   client,
+		rt:  rt,
 	}, nil
 }
 
+// LogQuota logs how much of GitHub's rate limit is left for each kind of
+// request made so far.
+func (ghg *GitHubGateway) LogQuota() {
+	ghg.rt.logQuota()
+}
+
 // GetIssues downloads and returns the issues for the user authenticated
 // to c, transformed to GitHubItems.
 func (ghg *GitHubGateway) GetIssues() ([]GitHubItem, error) {
+	if ghg.UseGraphQL {
+		return ghg.searchGraphQL("is:open assignee:@me")
+	}
 	opt := &github.IssueListOptions{
 		ListOptions: github.ListOptions{PerPage: paginationPerPage},
 	}
@@ -86,24 +124,39 @@ func (ghg *GitHubGateway) GetIssues() ([]GitHubItem, error) {
 
 	items := []GitHubItem{}
 	for _, issue := range issues {
-		item := GitHubItem{
-			Title:   strings.TrimSpace(issue.GetTitle()),
-			HTMLURL: issue.GetHTMLURL(),
-			APIURL:  issue.GetURL(),
-			K:       fmt.Sprintf("%s#%d", issue.GetRepository().GetFullName(), issue.GetNumber()),
-		}
-		items = append(items, item)
+		items = append(items, issueItem(issue))
 	}
 
 	return items, nil
 }
 
+// issueItem transforms an issue or PR into a GitHubItem.
+func issueItem(issue *github.Issue) GitHubItem {
+	item := GitHubItem{
+		Title:     strings.TrimSpace(issue.GetTitle()),
+		HTMLURL:   issue.GetHTMLURL(),
+		APIURL:    issue.GetURL(),
+		K:         fmt.Sprintf("%s#%d", issue.GetRepository().GetFullName(), issue.GetNumber()),
+		UpdatedAt: issue.GetUpdatedAt(),
+		Milestone: issue.GetMilestone().GetTitle(),
+	}
+	for _, l := range issue.Labels {
+		item.Labels = append(item.Labels, l.GetName())
+	}
+	return item
+}
+
+// GetPRs downloads and returns the open PRs whose review has been
+// requested from the user authenticated to c, transformed to GitHubItems.
 func (ghg *GitHubGateway) GetPRs() ([]GitHubItem, error) {
-	user, _, err := ghg.c.Users.Get(ghg.ctx, "")
+	if ghg.UseGraphQL {
+		return ghg.searchGraphQL("is:pr is:open review-requested:@me")
+	}
+	login, err := ghg.getLogin()
 	if err != nil {
 		return nil, err
 	}
-	query := "type:pr state:open review-requested:" + user.GetLogin()
+	query := "type:pr state:open review-requested:" + login
 
 	issues := []*github.Issue{}
 	opt := &github.SearchOptions{
@@ -124,112 +177,378 @@ func (ghg *GitHubGateway) GetPRs() ([]GitHubItem, error) {
 
 	items := []GitHubItem{}
 	for _, issue := range issues {
-		item := GitHubItem{
-			Title:   strings.TrimSpace(issue.GetTitle()),
-			HTMLURL: issue.GetHTMLURL(),
-			APIURL:  issue.GetURL(),
-			K:       fmt.Sprintf("%s#%d", issue.GetRepository().GetFullName(), issue.GetNumber()),
-		}
-		items = append(items, item)
+		items = append(items, issueItem(issue))
 	}
 	return items, nil
 }
 
+// NotificationPoll records the last time notifications were retrieved,
+// so that later runs only retrieve them again once GitHub says they may
+// have changed.
+type NotificationPoll struct {
+	// LastModified is the Last-Modified header GitHub sent with the
+	// notifications, which is sent back as If-Modified-Since.
+	LastModified string `json:"lastModified,omitempty"`
+	// IntervalSeconds is how long GitHub asked us to wait before polling
+	// again, from the X-Poll-Interval header.
+	IntervalSeconds int       `json:"intervalSeconds,omitempty"`
+	PolledAt        time.Time `json:"polledAt"`
+	// Items are the notifications retrieved, reused while they haven't
+	// changed. It's nil if notifications haven't been retrieved.
+	Items []GitHubItem `json:"items"`
+}
+
+// GetNotifications downloads and returns the notifications for the user
+// authenticated to c, transformed to GitHubItems.
 func (ghg *GitHubGateway) GetNotifications() ([]GitHubItem, error) {
-	// Retrieve
-	opt := &github.NotificationListOptions{
-		ListOptions: github.ListOptions{PerPage: paginationPerPage},
+	items, _, err := ghg.PollNotifications(NotificationPoll{}, time.Now())
+	return items, err
+}
+
+// PollNotifications returns the notifications for the user authenticated
+// to c, along with the poll to pass to the next call. The notifications in
+// prev are reused, rather than downloaded again, if GitHub's poll interval
+// hasn't passed since prev, or if GitHub says they haven't changed; the
+// latter doesn't count against the rate limit.
+func (ghg *GitHubGateway) PollNotifications(prev NotificationPoll, now time.Time) ([]GitHubItem, NotificationPoll, error) {
+	interval := time.Duration(prev.IntervalSeconds) * time.Second
+	if prev.Items != nil && now.Before(prev.PolledAt.Add(interval)) {
+		log.Printf("Reusing notifications; GitHub asks us to poll at most every %s", interval)
+		return prev.Items, prev, nil
 	}
+
+	// Retrieve
+	next := NotificationPoll{PolledAt: now}
 	notifications := []*github.Notification{}
-	for {
-		log.Printf("Getting Notifications page %d", opt.Page)
-		results, resp, err := ghg.c.Activity.ListNotifications(ghg.ctx, opt)
+	for page := 1; page != 0; {
+		log.Printf("Getting Notifications page %d", page)
+		u := fmt.Sprintf("notifications?per_page=%d&page=%d", paginationPerPage, page)
+		req, err := ghg.c.NewRequest("GET", u, nil)
 		if err != nil {
-			return nil, err
+			return nil, prev, err
 		}
-		notifications = append(notifications, results...)
-		if resp.NextPage == 0 {
-			break
+		// Only the first page is conditional, as the rest must be
+		// retrieved if it has changed.
+		first := page == 1
+		if first && prev.Items != nil && prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
 		}
-		opt.Page = resp.NextPage
+		var results []*github.Notification
+		resp, err := ghg.c.Do(ghg.ctx, req, &results)
+		if first && resp != nil {
+			next.IntervalSeconds, _ = strconv.Atoi(resp.Header.Get("X-Poll-Interval"))
+			next.LastModified = resp.Header.Get("Last-Modified")
+			if resp.StatusCode == http.StatusNotModified {
+				log.Printf("Notifications unchanged since %s", prev.LastModified)
+				if next.LastModified == "" {
+					next.LastModified = prev.LastModified
+				}
+				next.Items = prev.Items
+				return next.Items, next, nil
+			}
+		}
+		if err != nil {
+			return nil, prev, err
+		}
+		notifications = append(notifications, results...)
+		page = resp.NextPage
 	}
 
 	// Transform
 	items := []GitHubItem{}
 	for _, notification := range notifications {
-		// notification.Subject.GetURL() is
-		// - ${baseUrl}/repos/cloudant/infra/issues/1500
-		// - ${baseUrl}/repos/cloudant/infra/commits/b63a54879672ba25e6fd9c7cf5547ba118b7f6ae
-		parts := strings.Split(notification.Subject.GetURL(), "/")
-		lp := len(parts)
-
-		if lp < 5 {
-			// We should find at least 5 parts: host+"repos", owner, repo, type, ID
-			// https://github.com/mikerhodes/github-to-omnifocus/issues/10
-			continue
-		}
-
-		owner, repo, urlType, subjectID := parts[lp-4], parts[lp-3], parts[lp-2], parts[lp-1]
-		if !(urlType == "issues" || urlType == "commits" || urlType == "pulls") {
-			wrappedErr := fmt.Errorf(
-				"unrecognised notification type, can't determine subjectID: %s",
-				notification.Subject.GetURL(),
-			)
+		item, ok := notificationItem(notification)
+		if !ok {
 			// it seems like most people would rather the app didn't die because
 			// of we didn't recognise the notification type, so log & continue
 			// rather than returning
-			log.Printf("%v", wrappedErr)
+			log.Printf("unrecognised notification type %s, can't determine subjectID: %s",
+				notification.Subject.GetType(), notification.Subject.GetURL())
+			continue
+		}
+		items = append(items, item)
+	}
+
+	next.Items = items
+	return items, next, nil
+}
+
+// ResolveHTMLURLs fills in the HTMLURL of each item in items that doesn't
+// have one. URLs are taken from cache, which maps an item's HTMLURLSource to
+// its HTML URL, where they can be; the rest are retrieved from GitHub, a few
+// at a time, and added to cache. As a notification's source is its latest
+// comment, only items that are new or have a new comment miss the cache.
+func (ghg *GitHubGateway) ResolveHTMLURLs(items []GitHubItem, cache map[string]string) error {
+	missing := []int{}
+	for i, item := range items {
+		if item.HTMLURL != "" || item.HTMLURLSource == "" {
+			continue
+		}
+		if u, ok := cache[item.HTMLURLSource]; ok {
+			items[i].HTMLURL = u
 			continue
-			// return nil, wrappedErr
-		}
-
-		// Some notifications come with an API link to a comment, via
-		// notification.Subject.GetLatestCommentURL(). This can either point to
-		// a comment (${baseUrl}/repos/cloudant/infra/issues/comments/20486062)
-		// or I've also seen just the issue (shrug!) API URL for issues that are
-		// closed. In case GetLatestCommentURL() is blank, we fall back to
-		// notification.Subject.GetURL().
-		//
-		// Annoyingly, the notification only comes with the API URLs for both
-		// the comment and issue. This means that we have to retrive the item
-		// using a second network request to grab its HTML URL (we could build
-		// it from the API URL but that feels fragile).
-		//
-		// Later, we can optimise this to only retrieve for new items, but for
-		// now we'll leave as-is. Broadly speaking, we'd need to capture the
-		// ctx/client in a closure and use that to later get the HTMLURL.
-		//
-		// As we could be receiving a comment or an issue, and we only care
-		// about the common-to-both html_url field, we just deserialise into a
-		// struct that contains only that field.
-		type HTMLURLThing struct {
-			HTMLURL string `json:"html_url,omitempty"`
-		}
-		var req *http.Request
-		var err error
-		if notification.Subject.GetLatestCommentURL() != "" {
-			req, err = ghg.c.NewRequest("GET", notification.Subject.GetLatestCommentURL(), nil)
-		} else {
-			req, err = ghg.c.NewRequest("GET", notification.Subject.GetURL(), nil)
 		}
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if ghg.UseGraphQL {
+		err := ghg.resolveGraphQL(items, missing)
+		if err != nil {
+			return err
+		}
+		// Anything GraphQL couldn't find is looked up using the REST API.
+		rest := []int{}
+		for _, i := range missing {
+			if items[i].HTMLURL == "" {
+				rest = append(rest, i)
+			} else {
+				cache[items[i].HTMLURLSource] = items[i].HTMLURL
+			}
+		}
+		missing = rest
+		if len(missing) == 0 {
+			return nil
+		}
+	}
+	log.Printf("Getting HTML URLs for %d notifications", len(missing))
+
+	urls := make([]string, len(missing))
+	errs := make([]error, len(missing))
+	sem := make(chan struct{}, resolveConcurrency)
+	var wg sync.WaitGroup
+	for j, i := range missing {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			urls[j], errs[j] = ghg.getHTMLURL(items[i].HTMLURLSource)
+		}()
+	}
+	wg.Wait()
+
+	for j, i := range missing {
+		if errs[j] != nil {
+			return errs[j]
+		}
+		items[i].HTMLURL = urls[j]
+		cache[items[i].HTMLURLSource] = urls[j]
+	}
+	return nil
+}
+
+// getHTMLURL retrieves the HTML URL of the issue or comment at the API URL
+// apiURL.
+func (ghg *GitHubGateway) getHTMLURL(apiURL string) (string, error) {
+	// As we could be receiving a comment or an issue, and we only care
+	// about the common-to-both html_url field, we just deserialise into a
+	// struct that contains only that field.
+	type HTMLURLThing struct {
+		HTMLURL string `json:"html_url,omitempty"`
+	}
+	req, err := ghg.c.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request for notification's issue or comment: %v", err)
+	}
+	var issueOrComment HTMLURLThing
+	_, err = ghg.c.Do(ghg.ctx, req, &issueOrComment)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving notification's issue or comment: %v", err)
+	}
+	return issueOrComment.HTMLURL, nil
+}
+
+// GoneReason says why an item that had a task is no longer returned by
+// GitHub.
+type GoneReason int
+
+const (
+	// GoneUnknown means we couldn't tell, for example for a notification
+	// about a commit.
+	GoneUnknown GoneReason = iota
+	// GoneOpen means the issue or PR is still open, so it was unassigned,
+	// the review request was withdrawn or the notification was read
+	// elsewhere.
+	GoneOpen
+	// GoneClosed means the issue or PR was closed or merged by someone
+	// else.
+	GoneClosed
+	// GoneClosedByMe means the issue or PR was closed or merged by the
+	// authenticated user, or closed by their commit or PR, or is their PR
+	// and was merged.
+	GoneClosedByMe
+	// GoneReviewedByMe means the authenticated user reviewed the PR, so
+	// it's no longer awaiting their review.
+	GoneReviewedByMe
+)
+
+func (r GoneReason) String() string {
+	switch r {
+	case GoneOpen:
+		return "still open"
+	case GoneClosed:
+		return "closed by someone else"
+	case GoneClosedByMe:
+		return "closed by me"
+	case GoneReviewedByMe:
+		return "reviewed by me"
+	}
+	return "unknown"
+}
+
+// WhyGone looks up the issue or PR with the given key, in the form
+// owner/repo#number, to find out why it no longer needs a task. If review
+// is true, the item was a PR awaiting the user's review, and its reviews
+// are checked for one by the user. Keys that aren't for an issue or PR
+// return GoneUnknown.
+func (ghg *GitHubGateway) WhyGone(key string, review bool) (GoneReason, error) {
+	owner, repo, number, ok := parseKey(key)
+	if !ok {
+		return GoneUnknown, nil
+	}
+	login, err := ghg.getLogin()
+	if err != nil {
+		return GoneUnknown, err
+	}
+
+	if review {
+		reviewed, err := ghg.reviewedBy(owner, repo, number, login)
+		if err != nil {
+			return GoneUnknown, fmt.Errorf("error retrieving reviews of %s: %v", key, err)
+		}
+		if reviewed {
+			return GoneReviewedByMe, nil
+		}
+	}
+
+	issue, _, err := ghg.c.Issues.Get(ghg.ctx, owner, repo, number)
+	if err != nil {
+		return GoneUnknown, fmt.Errorf("error retrieving %s: %v", key, err)
+	}
+	if issue.GetState() != "closed" {
+		return GoneOpen, nil
+	}
+	if issue.GetClosedBy().GetLogin() == login {
+		return GoneClosedByMe, nil
+	}
+
+	if issue.IsPullRequest() {
+		pr, _, err := ghg.c.PullRequests.Get(ghg.ctx, owner, repo, number)
 		if err != nil {
-			return nil, fmt.Errorf("error creating request for notification's issue or comment: %v", err)
+			return GoneUnknown, fmt.Errorf("error retrieving PR %s: %v", key, err)
+		}
+		if pr.GetMerged() && (pr.GetMergedBy().GetLogin() == login || pr.GetUser().GetLogin() == login) {
+			return GoneClosedByMe, nil
 		}
-		var issueOrComment HTMLURLThing
-		_, err = ghg.c.Do(ghg.ctx, req, &issueOrComment)
+		return GoneClosed, nil
+	}
+
+	// An issue closed by a commit or PR is closed by whoever merged it, so
+	// check who wrote it.
+	mine, err := ghg.closedByMyCommit(owner, repo, number, login)
+	if err != nil {
+		return GoneUnknown, fmt.Errorf("error retrieving events of %s: %v", key, err)
+	}
+	if mine {
+		return GoneClosedByMe, nil
+	}
+	return GoneClosed, nil
+}
+
+// reviewedBy returns true if login has reviewed the PR owner/repo#number.
+func (ghg *GitHubGateway) reviewedBy(owner, repo string, number int, login string) (bool, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		reviews, resp, err := ghg.c.PullRequests.ListReviews(ghg.ctx, owner, repo, number, opts)
 		if err != nil {
-			return nil, fmt.Errorf("error retrieving notification's issue or comment: %v", err)
+			return false, err
 		}
-		htmlURL := issueOrComment.HTMLURL
+		for _, r := range reviews {
+			if r.GetUser().GetLogin() == login {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
 
-		item := GitHubItem{
-			Title:   strings.TrimSpace(notification.Subject.GetTitle()),
-			HTMLURL: htmlURL,
-			APIURL:  notification.Subject.GetURL(),
-			K:       fmt.Sprintf("%s/%s#%s", owner, repo, subjectID),
+// closedByMyCommit returns true if the issue owner/repo#number was last
+// closed by a commit that login wrote, or that is part of a PR login
+// opened.
+func (ghg *GitHubGateway) closedByMyCommit(owner, repo string, number int, login string) (bool, error) {
+	sha := ""
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		events, resp, err := ghg.c.Issues.ListIssueEvents(ghg.ctx, owner, repo, number, opts)
+		if err != nil {
+			return false, err
 		}
-		items = append(items, item)
+		for _, e := range events {
+			if e.GetEvent() == "closed" {
+				sha = e.GetCommitID()
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	if sha == "" {
+		return false, nil
 	}
 
-	return items, nil
+	// The commit may be in another repository, in which case we can't
+	// tell whose it is from here.
+	prs, _, err := ghg.c.PullRequests.ListPullRequestsWithCommit(ghg.ctx, owner, repo, sha, nil)
+	if err != nil {
+		log.Printf("Can't find the PR for commit %s that closed %s/%s#%d: %v", sha, owner, repo, number, err)
+		return false, nil
+	}
+	for _, pr := range prs {
+		if pr.GetUser().GetLogin() == login {
+			return true, nil
+		}
+	}
+	commit, _, err := ghg.c.Repositories.GetCommit(ghg.ctx, owner, repo, sha, nil)
+	if err != nil {
+		log.Printf("Can't find commit %s that closed %s/%s#%d: %v", sha, owner, repo, number, err)
+		return false, nil
+	}
+	return commit.GetAuthor().GetLogin() == login, nil
+}
+
+// parseKey splits an item key of the form owner/repo#number. Keys for
+// other things, such as owner/repo/discussions#12, aren't split.
+func parseKey(key string) (owner, repo string, number int, ok bool) {
+	fullName, num, found := strings.Cut(key, "#")
+	if !found {
+		return "", "", 0, false
+	}
+	owner, repo, found = strings.Cut(fullName, "/")
+	if !found || strings.Contains(repo, "/") {
+		return "", "", 0, false
+	}
+	number, err := strconv.Atoi(num)
+	if err != nil {
+		return "", "", 0, false
+	}
+	return owner, repo, number, true
+}
+
+// getLogin returns the authenticated user's login.
+func (ghg *GitHubGateway) getLogin() (string, error) {
+	if ghg.login == "" {
+		user, _, err := ghg.c.Users.Get(ghg.ctx, "")
+		if err != nil {
+			return "", err
+		}
+		ghg.login = user.GetLogin()
+	}
+	return ghg.login, nil
 }
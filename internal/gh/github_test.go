@@ -0,0 +1,157 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolveHTMLURLs(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprintf(w, `{"html_url": "https://github.example.com/html%s"}`, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	ghg, err := NewGitHubGateway(context.Background(), "token", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached := srv.URL + "/repos/a/b/issues/comments/1"
+	items := []GitHubItem{
+		{K: "a/b#1", HTMLURLSource: cached},
+		{K: "a/b#2", HTMLURLSource: srv.URL + "/repos/a/b/issues/comments/2"},
+		{K: "a/b#3", HTMLURL: "https://github.example.com/a/b/issues/3"},
+	}
+	cache := map[string]string{cached: "https://github.example.com/cached"}
+
+	if err := ghg.ResolveHTMLURLs(items, cache); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("Expected only the uncached item to be retrieved, got %d requests", n)
+	}
+	if items[0].HTMLURL != "https://github.example.com/cached" {
+		t.Fatalf("Expected cached URL, got %q", items[0].HTMLURL)
+	}
+	want := "https://github.example.com/html/repos/a/b/issues/comments/2"
+	if items[1].HTMLURL != want || cache[items[1].HTMLURLSource] != want {
+		t.Fatalf("Expected %q to be resolved and cached, got %q", want, items[1].HTMLURL)
+	}
+	if items[2].HTMLURL != "https://github.example.com/a/b/issues/3" {
+		t.Fatalf("Expected item with a URL to be left alone, got %q", items[2].HTMLURL)
+	}
+}
+
+func TestPollNotifications(t *testing.T) {
+	const lastModified = "Thu, 28 Mar 2024 10:00:00 GMT"
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("X-Poll-Interval", "60")
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		fmt.Fprint(w, `[{"subject": {"title": "a PR", "url": "https://api.example.com/repos/a/b/pulls/1"}}]`)
+	}))
+	defer srv.Close()
+
+	ghg, err := NewGitHubGateway(context.Background(), "token", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	items, poll, err := ghg.PollNotifications(NotificationPoll{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Key() != "a/b#1" {
+		t.Fatalf("Expected a single notification, got %v", items)
+	}
+	if poll.LastModified != lastModified || poll.IntervalSeconds != 60 {
+		t.Fatalf("Expected validators to be recorded, got %+v", poll)
+	}
+
+	// Within the poll interval, GitHub isn't asked at all.
+	items, _, err = ghg.PollNotifications(poll, now.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 1 || len(items) != 1 {
+		t.Fatalf("Expected notifications to be reused without a request, got %d requests and %v", n, items)
+	}
+
+	// After it, GitHub says nothing has changed.
+	items, next, err := ghg.PollNotifications(poll, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 2 || len(items) != 1 || items[0].Key() != "a/b#1" {
+		t.Fatalf("Expected notifications to be reused after a 304, got %d requests and %v", n, items)
+	}
+	if next.LastModified != lastModified || !next.PolledAt.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("Expected poll to be updated, got %+v", next)
+	}
+}
+
+func TestWhyGone(t *testing.T) {
+	responses := map[string]string{
+		"/api/v3/user": `{"login": "me"}`,
+		// a/b#1 is an open PR that I've reviewed.
+		"/api/v3/repos/a/b/pulls/1/reviews": `[{"user": {"login": "someone"}}, {"user": {"login": "me"}}]`,
+		// a/b#2 is an open PR that I haven't.
+		"/api/v3/repos/a/b/pulls/2/reviews": `[{"user": {"login": "someone"}}]`,
+		"/api/v3/repos/a/b/issues/2":        `{"state": "open", "pull_request": {}}`,
+		// a/b#3 is an issue closed by my PR, merged by someone else.
+		"/api/v3/repos/a/b/issues/3":          `{"state": "closed", "closed_by": {"login": "someone"}}`,
+		"/api/v3/repos/a/b/issues/3/events":   `[{"event": "referenced", "commit_id": "abc"}, {"event": "closed", "commit_id": "def"}]`,
+		"/api/v3/repos/a/b/commits/def/pulls": `[{"user": {"login": "me"}}]`,
+		// a/b#4 is an issue someone else closed by hand.
+		"/api/v3/repos/a/b/issues/4":        `{"state": "closed", "closed_by": {"login": "someone"}}`,
+		"/api/v3/repos/a/b/issues/4/events": `[{"event": "closed"}]`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			t.Errorf("Unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	ghg, err := NewGitHubGateway(context.Background(), "token", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		key    string
+		review bool
+		want   GoneReason
+	}{
+		{"a/b#1", true, GoneReviewedByMe},
+		{"a/b#2", true, GoneOpen},
+		{"a/b#3", false, GoneClosedByMe},
+		{"a/b#4", false, GoneClosed},
+		{"a/b/discussions#5", false, GoneUnknown},
+	}
+	for _, c := range cases {
+		got, err := ghg.WhyGone(c.key, c.review)
+		if err != nil {
+			t.Fatalf("%s: %v", c.key, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: got %s, want %s", c.key, got, c.want)
+		}
+	}
+}
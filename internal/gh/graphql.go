@@ -0,0 +1,311 @@
+package gh
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// graphQLPageSize is the most items a GraphQL search returns at once.
+const graphQLPageSize = 100
+
+// graphQLBatchSize is the most notification subjects looked up in one
+// GraphQL query.
+const graphQLBatchSize = 50
+
+// graphQLRequest is the body of a request to GitHub's GraphQL API.
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// graphQLError is an error returned by GitHub's GraphQL API. A query can
+// return both data and errors, such as when a repository can't be seen.
+// Path leads to the field the error is for, and holds both field names
+// and list indexes.
+type graphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path"`
+}
+
+func (e graphQLError) String() string {
+	if len(e.Path) == 0 {
+		return e.Message
+	}
+	path := []string{}
+	for _, p := range e.Path {
+		path = append(path, fmt.Sprint(p))
+	}
+	return fmt.Sprintf("%s (at %s)", e.Message, strings.Join(path, "."))
+}
+
+// graphQLURL returns the URL of the GraphQL API that goes with the REST
+// API the client uses: https://api.github.com/graphql for GitHub, and
+// https://host/api/graphql for GitHub Enterprise.
+func (ghg *GitHubGateway) graphQLURL() string {
+	u := *ghg.c.BaseURL
+	if strings.HasSuffix(u.Path, "/api/v3/") {
+		u.Path = strings.TrimSuffix(u.Path, "v3/") + "graphql"
+	} else {
+		u.Path += "graphql"
+	}
+	return u.String()
+}
+
+// graphQL runs query with variables, decoding its data into data. Errors
+// for part of the query are returned as errs rather than failing the
+// whole query.
+func (ghg *GitHubGateway) graphQL(query string, variables map[string]interface{}, data interface{}) (errs []graphQLError, err error) {
+	req, err := ghg.c.NewRequest("POST", ghg.graphQLURL(), graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return nil, fmt.Errorf("error creating GraphQL request: %v", err)
+	}
+	resp := struct {
+		Data   interface{}    `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}{Data: data}
+	_, err = ghg.c.Do(ghg.ctx, req, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error running GraphQL query: %v", err)
+	}
+	return resp.Errors, nil
+}
+
+// searchQuery finds the open issues and PRs matching a search, with
+// enough about each to make a GitHubItem.
+const searchQuery = `query($q: String!, $first: Int!, $after: String) {
+  search(query: $q, type: ISSUE, first: $first, after: $after) {
+    pageInfo { hasNextPage endCursor }
+    nodes {
+      ... on Issue {
+        number title url updatedAt
+        repository { nameWithOwner }
+        labels(first: 20) { nodes { name } }
+        milestone { title }
+      }
+      ... on PullRequest {
+        number title url updatedAt isDraft
+        repository { nameWithOwner }
+        labels(first: 20) { nodes { name } }
+        milestone { title }
+      }
+    }
+  }
+}`
+
+type searchNode struct {
+	Number     int       `json:"number"`
+	Title      string    `json:"title"`
+	URL        string    `json:"url"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	IsDraft    bool      `json:"isDraft"`
+	Repository struct {
+		NameWithOwner string `json:"nameWithOwner"`
+	} `json:"repository"`
+	Labels struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"labels"`
+	Milestone *struct {
+		Title string `json:"title"`
+	} `json:"milestone"`
+}
+
+// searchGraphQL returns the issues and PRs matching the search q, as
+// GitHubItems like those GetIssues and GetPRs return. Items GitHub can't
+// return, such as those in organisations needing SAML sign-on, are logged
+// and left out, as the REST API does.
+func (ghg *GitHubGateway) searchGraphQL(q string) ([]GitHubItem, error) {
+	items := []GitHubItem{}
+	variables := map[string]interface{}{"q": q, "first": graphQLPageSize}
+	for page := 1; ; page++ {
+		log.Printf("Getting GraphQL search page %d: %s", page, q)
+		var data struct {
+			Search *struct {
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+				Nodes []searchNode `json:"nodes"`
+			} `json:"search"`
+		}
+		errs, err := ghg.graphQL(searchQuery, variables, &data)
+		if err != nil {
+			return nil, err
+		}
+		if data.Search == nil {
+			if len(errs) > 0 {
+				return nil, fmt.Errorf("error searching for %q: %s", q, errs[0])
+			}
+			return nil, fmt.Errorf("error searching for %q: no results returned", q)
+		}
+		for _, e := range errs {
+			log.Printf("GraphQL: %s", e)
+		}
+		for _, n := range data.Search.Nodes {
+			if n.Number == 0 {
+				// Not an issue or PR.
+				continue
+			}
+			items = append(items, ghg.searchItem(n))
+		}
+		if !data.Search.PageInfo.HasNextPage {
+			return items, nil
+		}
+		variables["after"] = data.Search.PageInfo.EndCursor
+	}
+}
+
+// searchItem transforms a search result into a GitHubItem.
+func (ghg *GitHubGateway) searchItem(n searchNode) GitHubItem {
+	item := GitHubItem{
+		Title:     strings.TrimSpace(n.Title),
+		HTMLURL:   n.URL,
+		APIURL:    fmt.Sprintf("%srepos/%s/issues/%d", ghg.c.BaseURL, n.Repository.NameWithOwner, n.Number),
+		K:         fmt.Sprintf("%s#%d", n.Repository.NameWithOwner, n.Number),
+		UpdatedAt: n.UpdatedAt,
+		Draft:     n.IsDraft,
+	}
+	for _, l := range n.Labels.Nodes {
+		item.Labels = append(item.Labels, l.Name)
+	}
+	if n.Milestone != nil {
+		item.Milestone = n.Milestone.Title
+	}
+	return item
+}
+
+// subject is what's needed to find the HTML URL of a notification's
+// subject using GraphQL: the issue, PR or commit, and the fragment that
+// picks out its latest comment, if any.
+type subject struct {
+	owner, repo string
+	number      int
+	sha         string
+	fragment    string
+}
+
+// parseSubject works out the subject of a notification from its API URL,
+// such as ${baseUrl}/repos/cloudant/infra/issues/1500, and the API URL of
+// its latest comment, if it has one.
+func parseSubject(apiURL, commentURL string) (subject, bool) {
+	parts := strings.Split(apiURL, "/")
+	lp := len(parts)
+	if lp < 5 || parts[lp-5] != "repos" {
+		return subject{}, false
+	}
+	s := subject{owner: parts[lp-4], repo: parts[lp-3]}
+	switch parts[lp-2] {
+	case "issues", "pulls":
+		n, err := strconv.Atoi(parts[lp-1])
+		if err != nil {
+			return subject{}, false
+		}
+		s.number = n
+	case "commits":
+		s.sha = parts[lp-1]
+	default:
+		return subject{}, false
+	}
+
+	if commentURL == "" || commentURL == apiURL {
+		return s, true
+	}
+	// The HTML pages for issues, PRs and commits anchor their comments
+	// by the comment's ID.
+	c := strings.Split(commentURL, "/")
+	lc := len(c)
+	if lc < 3 {
+		return subject{}, false
+	}
+	id := c[lc-1]
+	if _, err := strconv.Atoi(id); err != nil {
+		return subject{}, false
+	}
+	switch {
+	case c[lc-3] == "issues" && c[lc-2] == "comments":
+		s.fragment = "#issuecomment-" + id
+	case c[lc-3] == "pulls" && c[lc-2] == "comments":
+		s.fragment = "#discussion_r" + id
+	case c[lc-2] == "comments":
+		s.fragment = "#commitcomment-" + id
+	default:
+		return subject{}, false
+	}
+	return s, true
+}
+
+// resolveGraphQL looks up the HTML URLs of the notifications items[i] for
+// each i in indexes in batched GraphQL queries. Items that can't be
+// looked up this way are left without an HTML URL.
+func (ghg *GitHubGateway) resolveGraphQL(items []GitHubItem, indexes []int) error {
+	for start := 0; start < len(indexes); start += graphQLBatchSize {
+		batch := indexes[start:min(start+graphQLBatchSize, len(indexes))]
+
+		subjects := map[string]subject{}
+		vars := []string{}
+		fields := []string{}
+		variables := map[string]interface{}{}
+		for _, i := range batch {
+			s, ok := parseSubject(items[i].APIURL, items[i].HTMLURLSource)
+			if !ok {
+				continue
+			}
+			a := "s" + strconv.Itoa(i)
+			subjects[a] = s
+			variables[a+"o"], variables[a+"r"] = s.owner, s.repo
+			vars = append(vars, fmt.Sprintf("$%so: String!, $%sr: String!", a, a))
+			if s.sha != "" {
+				variables[a+"x"] = s.sha
+				vars = append(vars, fmt.Sprintf("$%sx: String!", a))
+				fields = append(fields, fmt.Sprintf(
+					"%s: repository(owner: $%so, name: $%sr) { object(expression: $%sx) { ... on Commit { url } } }",
+					a, a, a, a))
+			} else {
+				variables[a+"n"] = s.number
+				vars = append(vars, fmt.Sprintf("$%sn: Int!", a))
+				fields = append(fields, fmt.Sprintf(
+					"%s: repository(owner: $%so, name: $%sr) { issueOrPullRequest(number: $%sn) { ... on Issue { url } ... on PullRequest { url } } }",
+					a, a, a, a))
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		log.Printf("Getting HTML URLs for %d notifications with GraphQL", len(fields))
+		query := fmt.Sprintf("query(%s) {\n%s\n}", strings.Join(vars, ", "), strings.Join(fields, "\n"))
+		type urlOnly struct {
+			URL string `json:"url"`
+		}
+		data := map[string]*struct {
+			Object             *urlOnly `json:"object"`
+			IssueOrPullRequest *urlOnly `json:"issueOrPullRequest"`
+		}{}
+		errs, err := ghg.graphQL(query, variables, &data)
+		if err != nil {
+			return err
+		}
+		for _, e := range errs {
+			log.Printf("GraphQL: %s", e)
+		}
+
+		for _, i := range batch {
+			a := "s" + strconv.Itoa(i)
+			r := data[a]
+			if r == nil {
+				continue
+			}
+			switch {
+			case r.Object != nil && r.Object.URL != "":
+				items[i].HTMLURL = r.Object.URL + subjects[a].fragment
+			case r.IssueOrPullRequest != nil && r.IssueOrPullRequest.URL != "":
+				items[i].HTMLURL = r.IssueOrPullRequest.URL + subjects[a].fragment
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGraphQLURL(t *testing.T) {
+	cases := map[string]string{
+		"https://api.github.com":             "https://api.github.com/graphql",
+		"https://github.example.com/api/v3":  "https://github.example.com/api/graphql",
+		"https://github.example.com/api/v3/": "https://github.example.com/api/graphql",
+	}
+	for apiURL, want := range cases {
+		ghg, err := NewGitHubGateway(context.Background(), "token", apiURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ghg.graphQLURL(); got != want {
+			t.Errorf("%s: got %s, want %s", apiURL, got, want)
+		}
+	}
+}
+
+func TestParseSubject(t *testing.T) {
+	base := "https://api.github.com/repos/a/b/"
+	cases := []struct {
+		apiURL, commentURL string
+		want               subject
+	}{
+		{base + "issues/1", "", subject{owner: "a", repo: "b", number: 1}},
+		{base + "pulls/2", base + "pulls/2", subject{owner: "a", repo: "b", number: 2}},
+		{base + "issues/1", base + "issues/comments/9", subject{owner: "a", repo: "b", number: 1, fragment: "#issuecomment-9"}},
+		{base + "pulls/2", base + "pulls/comments/8", subject{owner: "a", repo: "b", number: 2, fragment: "#discussion_r8"}},
+		{base + "commits/abc", base + "comments/7", subject{owner: "a", repo: "b", sha: "abc", fragment: "#commitcomment-7"}},
+	}
+	for _, c := range cases {
+		got, ok := parseSubject(c.apiURL, c.commentURL)
+		if !ok || got != c.want {
+			t.Errorf("parseSubject(%q, %q) = %+v, %t; want %+v", c.apiURL, c.commentURL, got, ok, c.want)
+		}
+	}
+
+	if _, ok := parseSubject(base+"releases/1", ""); ok {
+		t.Errorf("Expected a release not to be parsed")
+	}
+	if _, ok := parseSubject(base+"pulls/2", base+"pulls/2/reviews/3"); ok {
+		t.Errorf("Expected an unknown comment URL not to be parsed")
+	}
+}
+
+func TestSearchGraphQL(t *testing.T) {
+	page := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/graphql" || r.Method != "POST" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var req graphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		page++
+		hasNext := page == 1
+		fmt.Fprintf(w, `{"data": {"search": {
+			"pageInfo": {"hasNextPage": %t, "endCursor": "c%d"},
+			"nodes": [{
+				"number": %d, "title": " a PR ", "url": "https://github.com/a/b/pull/%d",
+				"updatedAt": "2024-03-28T10:00:00Z", "isDraft": true,
+				"repository": {"nameWithOwner": "a/b"},
+				"labels": {"nodes": [{"name": "bug"}]},
+				"milestone": {"title": "v1"}
+			}, {}]
+		}}}`, hasNext, page, page, page)
+		if page == 2 && req.Variables["after"] != "c1" {
+			t.Errorf("Expected the second page to start after the first, got %v", req.Variables)
+		}
+	}))
+	defer srv.Close()
+
+	ghg, err := NewGitHubGateway(context.Background(), "token", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghg.UseGraphQL = true
+	items, err := ghg.GetPRs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expected an item from each page, got %v", items)
+	}
+	item := items[0]
+	if item.Title != "a PR" || item.HTMLURL != "https://github.com/a/b/pull/1" || item.K != "a/b#1" ||
+		item.APIURL != srv.URL+"/api/v3/repos/a/b/issues/1" || item.UpdatedAt.IsZero() {
+		t.Fatalf("Unexpected item %+v", item)
+	}
+	if strings.Join(item.Labels, ",") != "bug" || item.Milestone != "v1" || !item.Draft {
+		t.Fatalf("Expected labels, milestone and draft state, got %+v", item)
+	}
+}
+
+func TestSearchGraphQLPartialErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": {"search": {
+			"pageInfo": {"hasNextPage": false, "endCursor": "c1"},
+			"nodes": [{
+				"number": 1, "title": "visible", "url": "https://github.com/a/b/issues/1",
+				"updatedAt": "2024-03-28T10:00:00Z",
+				"repository": {"nameWithOwner": "a/b"},
+				"labels": {"nodes": []}
+			}, null]
+		}}, "errors": [{
+			"message": "Resource protected by organization SAML enforcement.",
+			"path": ["search", "nodes", 1]
+		}]}`)
+	}))
+	defer srv.Close()
+
+	ghg, err := NewGitHubGateway(context.Background(), "token", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghg.UseGraphQL = true
+	items, err := ghg.GetIssues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Title != "visible" {
+		t.Fatalf("Expected the visible item, got %v", items)
+	}
+}
+
+func TestResolveHTMLURLsGraphQL(t *testing.T) {
+	restRequests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/graphql" {
+			restRequests++
+			fmt.Fprint(w, `{"html_url": "https://github.com/a/b/releases/1"}`)
+			return
+		}
+		var req graphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(req.Query, "s0: repository(") || !strings.Contains(req.Query, "s1: repository(") {
+			t.Errorf("Expected both subjects in one query, got %s", req.Query)
+		}
+		fmt.Fprint(w, `{"data": {
+			"s0": {"issueOrPullRequest": {"url": "https://github.com/a/b/pull/2"}},
+			"s1": {"object": {"url": "https://github.com/a/b/commit/abc"}}
+		}}`)
+	}))
+	defer srv.Close()
+
+	ghg, err := NewGitHubGateway(context.Background(), "token", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghg.UseGraphQL = true
+	base := srv.URL + "/api/v3/repos/a/b/"
+	items := []GitHubItem{
+		{K: "a/b#2", APIURL: base + "pulls/2", HTMLURLSource: base + "issues/comments/9"},
+		{K: "a/b#abc", APIURL: base + "commits/abc", HTMLURLSource: base + "commits/abc"},
+		{K: "a/b#1", APIURL: base + "releases/1", HTMLURLSource: base + "releases/1"},
+	}
+	cache := map[string]string{}
+	if err := ghg.ResolveHTMLURLs(items, cache); err != nil {
+		t.Fatal(err)
+	}
+	wants := []string{
+		"https://github.com/a/b/pull/2#issuecomment-9",
+		"https://github.com/a/b/commit/abc",
+		"https://github.com/a/b/releases/1",
+	}
+	for i, want := range wants {
+		if items[i].HTMLURL != want || cache[items[i].HTMLURLSource] != want {
+			t.Errorf("Item %d: expected %q to be resolved and cached, got %q", i, want, items[i].HTMLURL)
+		}
+	}
+	if restRequests != 1 {
+		t.Errorf("Expected only the release to be looked up with REST, got %d requests", restRequests)
+	}
+}
@@ -0,0 +1,106 @@
+package gh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v41/github"
+)
+
+// notificationItem transforms a notification into a GitHubItem, returning
+// false if its subject is of a type we don't recognise.
+//
+// Each type of subject has its own form of key:
+//   - issues and PRs: owner/repo#12
+//   - commits: owner/repo#b63a548...
+//   - discussions: owner/repo/discussions#12
+//   - releases: owner/repo@v1.2.3
+//   - check suites and workflow runs: owner/repo/actions#<thread ID>
+//   - security alerts: owner/repo/security#<thread ID>
+//   - invitations: owner/repo/invitations#<thread ID>
+//
+// Subjects without an API URL of their own, such as check suites, are
+// keyed by the notification's thread and link to the relevant page of the
+// repository.
+func notificationItem(n *github.Notification) (GitHubItem, bool) {
+	item := GitHubItem{
+		Title:     strings.TrimSpace(n.Subject.GetTitle()),
+		APIURL:    n.Subject.GetURL(),
+		UpdatedAt: n.GetUpdatedAt(),
+	}
+	fullName := n.GetRepository().GetFullName()
+	repoHTMLURL := n.GetRepository().GetHTMLURL()
+
+	// notification.Subject.GetURL() is
+	// - ${baseUrl}/repos/cloudant/infra/issues/1500
+	// - ${baseUrl}/repos/cloudant/infra/commits/b63a54879672ba25e6fd9c7cf5547ba118b7f6ae
+	// - ${baseUrl}/repos/cloudant/infra/discussions/12
+	// - ${baseUrl}/repos/cloudant/infra/releases/81862467
+	// or empty for things that have no API of their own.
+	parts := strings.Split(n.Subject.GetURL(), "/")
+	lp := len(parts)
+	if lp >= 5 {
+		// We should find at least 5 parts: host+"repos", owner, repo, type, ID
+		// https://github.com/mikerhodes/github-to-omnifocus/issues/10
+		owner, repo, urlType, subjectID := parts[lp-4], parts[lp-3], parts[lp-2], parts[lp-1]
+		switch urlType {
+		case "issues", "commits", "pulls":
+			// Some notifications come with an API link to a comment, via
+			// notification.Subject.GetLatestCommentURL(). This can either point to
+			// a comment (${baseUrl}/repos/cloudant/infra/issues/comments/20486062)
+			// or I've also seen just the issue (shrug!) API URL for issues that are
+			// closed. In case GetLatestCommentURL() is blank, we fall back to
+			// notification.Subject.GetURL().
+			//
+			// Annoyingly, the notification only comes with the API URLs for both
+			// the comment and issue. This means that we have to retrive the item
+			// using a second network request to grab its HTML URL (we could build
+			// it from the API URL but that feels fragile). That's left to
+			// ResolveHTMLURLs, so it's only done for the items that need it.
+			item.HTMLURLSource = n.Subject.GetLatestCommentURL()
+			if item.HTMLURLSource == "" {
+				item.HTMLURLSource = n.Subject.GetURL()
+			}
+			item.K = fmt.Sprintf("%s/%s#%s", owner, repo, subjectID)
+			return item, true
+		case "discussions":
+			// There's no REST API for discussions to ask for the HTML URL.
+			item.HTMLURL = fmt.Sprintf("%s/discussions/%s", repoHTMLURL, subjectID)
+			item.K = fmt.Sprintf("%s/%s/discussions#%s", owner, repo, subjectID)
+			return item, true
+		case "releases":
+			// The subject's title is the release's name, usually its tag;
+			// names with spaces can't be used in a key, so fall back to the
+			// release's ID.
+			tag := item.Title
+			if tag == "" || strings.ContainsAny(tag, " \t") {
+				tag = "release-" + subjectID
+			}
+			item.HTMLURLSource = n.Subject.GetURL()
+			item.K = fmt.Sprintf("%s/%s@%s", owner, repo, tag)
+			return item, true
+		}
+	}
+
+	if fullName == "" || repoHTMLURL == "" {
+		return GitHubItem{}, false
+	}
+	thread := n.GetID()
+	switch n.Subject.GetType() {
+	case "Discussion":
+		item.HTMLURL = repoHTMLURL + "/discussions"
+		item.K = fmt.Sprintf("%s/discussions#t%s", fullName, thread)
+	case "CheckSuite", "WorkflowRun":
+		item.HTMLURL = repoHTMLURL + "/actions"
+		item.K = fmt.Sprintf("%s/actions#%s", fullName, thread)
+	case "RepositoryVulnerabilityAlert", "RepositoryDependabotAlertsThread":
+		item.HTMLURL = repoHTMLURL + "/security/dependabot"
+		item.K = fmt.Sprintf("%s/security#%s", fullName, thread)
+	case "RepositoryInvitation":
+		item.HTMLURL = repoHTMLURL + "/invitations"
+		item.K = fmt.Sprintf("%s/invitations#%s", fullName, thread)
+	default:
+		return GitHubItem{}, false
+	}
+	return item, true
+}
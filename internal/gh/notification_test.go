@@ -0,0 +1,70 @@
+package gh
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v41/github"
+)
+
+func TestNotificationItem(t *testing.T) {
+	api := "https://api.github.com/repos/a/b/"
+	html := "https://github.com/a/b"
+	cases := []struct {
+		subjectType, url, commentURL, title string
+		key, htmlURL, source                string
+	}{
+		{"Issue", api + "issues/1", api + "issues/comments/9", "an issue", "a/b#1", "", api + "issues/comments/9"},
+		{"PullRequest", api + "pulls/2", "", "a PR", "a/b#2", "", api + "pulls/2"},
+		{"Commit", api + "commits/abc", "", "a commit", "a/b#abc", "", api + "commits/abc"},
+		{"Discussion", api + "discussions/12", "", "a discussion", "a/b/discussions#12", html + "/discussions/12", ""},
+		{"Discussion", "", "", "a discussion", "a/b/discussions#t42", html + "/discussions", ""},
+		{"Release", api + "releases/81862467", "", "v1.2.3", "a/b@v1.2.3", "", api + "releases/81862467"},
+		{"Release", api + "releases/81862467", "", "Version 1.2.3", "a/b@release-81862467", "", api + "releases/81862467"},
+		{"CheckSuite", "", "", "CI workflow run failed", "a/b/actions#42", html + "/actions", ""},
+		{"RepositoryVulnerabilityAlert", "", "", "an alert", "a/b/security#42", html + "/security/dependabot", ""},
+		{"RepositoryInvitation", "", "", "an invitation", "a/b/invitations#42", html + "/invitations", ""},
+	}
+	for _, c := range cases {
+		n := &github.Notification{
+			ID: github.String("42"),
+			Repository: &github.Repository{
+				FullName: github.String("a/b"),
+				HTMLURL:  github.String(html),
+			},
+			Subject: &github.NotificationSubject{
+				Type:             github.String(c.subjectType),
+				Title:            github.String(c.title),
+				URL:              github.String(c.url),
+				LatestCommentURL: github.String(c.commentURL),
+			},
+		}
+		item, ok := notificationItem(n)
+		if !ok {
+			t.Errorf("%s %s: not recognised", c.subjectType, c.url)
+			continue
+		}
+		if item.Key() != c.key || item.HTMLURL != c.htmlURL || item.HTMLURLSource != c.source {
+			t.Errorf("%s %s: got key %q, URL %q, source %q; want %q, %q, %q",
+				c.subjectType, c.url, item.Key(), item.HTMLURL, item.HTMLURLSource, c.key, c.htmlURL, c.source)
+		}
+	}
+
+	n := &github.Notification{
+		Repository: &github.Repository{FullName: github.String("a/b"), HTMLURL: github.String(html)},
+		Subject:    &github.NotificationSubject{Type: github.String("Unknown")},
+	}
+	if _, ok := notificationItem(n); ok {
+		t.Errorf("Expected an unknown subject type not to be recognised")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if owner, repo, n, ok := parseKey("a/b#12"); !ok || owner != "a" || repo != "b" || n != 12 {
+		t.Errorf("Expected a/b#12 to be parsed, got %s %s %d %t", owner, repo, n, ok)
+	}
+	for _, key := range []string{"a/b#abc", "a/b/discussions#12", "a/b@v1.2.3", "a/b/actions#42"} {
+		if _, _, _, ok := parseKey(key); ok {
+			t.Errorf("Expected %s not to be parsed as an issue or PR", key)
+		}
+	}
+}
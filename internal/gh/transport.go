@@ -0,0 +1,235 @@
+package gh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// retryTransport is an http.RoundTripper that retries requests GitHub
+// turned away because of its rate limits or a transient error, waiting as
+// long as GitHub asks, or backing off with jitter when it doesn't say.
+// The total time spent waiting for a request is bounded by budget, after
+// which the last response is returned as is.
+type retryTransport struct {
+	base http.RoundTripper
+	// maxAttempts is the most times a request is sent.
+	maxAttempts int
+	// budget is the longest a request may spend waiting between attempts.
+	budget time.Duration
+	// backoff is the wait before the first retry of a transient error,
+	// doubling for each retry after.
+	backoff time.Duration
+	// sleep waits for d, or until ctx is done; replaceable in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+	now   func() time.Time
+
+	mu sync.Mutex
+	// quota holds the last rate limit headers seen for each resource, such
+	// as "core" or "search".
+	quota map[string]quota
+}
+
+type quota struct {
+	limit, remaining int
+	reset            time.Time
+}
+
+func newRetryTransport(base http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		base:        base,
+		maxAttempts: 5,
+		budget:      2 * time.Minute,
+		backoff:     time.Second,
+		sleep:       sleepContext,
+		now:         time.Now,
+		quota:       map[string]quota{},
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	waited := time.Duration(0)
+	for attempt := 1; ; attempt++ {
+		resp, err := rt.base.RoundTrip(req)
+		if resp != nil {
+			rt.record(resp)
+		}
+
+		wait, retry := rt.retryAfter(req, resp, err, attempt)
+		// We only read from GitHub, but make sure we never send a change
+		// twice. GraphQL queries are sent with POST, and we don't send
+		// mutations, so they can be retried too if their body can be
+		// recreated.
+		idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead ||
+			(strings.HasSuffix(req.URL.Path, "/graphql") && req.GetBody != nil)
+		if !retry || !idempotent || attempt >= rt.maxAttempts || waited+wait > rt.budget {
+			return resp, err
+		}
+
+		if err != nil {
+			log.Printf("GitHub request failed, retrying in %s: %v", wait.Round(time.Second), err)
+		} else {
+			log.Printf("GitHub returned %s, retrying in %s", resp.Status, wait.Round(time.Second))
+			resp.Body.Close()
+		}
+		if err := rt.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		waited += wait
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryAfter returns how long to wait before retrying req, whose
+// attempt'th try gave resp and err, and whether to retry at all.
+func (rt *retryTransport) retryAfter(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil {
+		if req.Context().Err() != nil || !transient(err) {
+			// The caller gave up, or trying again won't help.
+			return 0, false
+		}
+		return rt.jitter(attempt), true
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		// Secondary rate limits, such as the search API's, say how long
+		// to wait in Retry-After.
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(s) * time.Second, true
+		}
+		// The primary rate limit says when it resets.
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			// Allow a little for our clock being behind GitHub's.
+			return max(time.Unix(reset, 0).Sub(rt.now()), 0) + time.Second, true
+		}
+		// A secondary rate limit without Retry-After means waiting at
+		// least a minute.
+		if secondaryRateLimit(resp) {
+			return time.Minute + rt.jitter(attempt), true
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return rt.jitter(attempt), true
+		}
+		// Any other 403 is a permissions problem, which waiting won't fix.
+		return 0, false
+	case resp.StatusCode >= 500:
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(s) * time.Second, true
+		}
+		return rt.jitter(attempt), true
+	}
+	return 0, false
+}
+
+// secondaryRateLimit returns true if resp is GitHub saying a secondary rate
+// limit was hit, which it only says in the body. resp's body is left to be
+// read again.
+func secondaryRateLimit(resp *http.Response) bool {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil {
+		return false
+	}
+	var e struct {
+		Message          string `json:"message"`
+		DocumentationURL string `json:"documentation_url"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return false
+	}
+	return strings.HasSuffix(e.DocumentationURL, "#secondary-rate-limits") ||
+		strings.HasSuffix(e.DocumentationURL, "#abuse-rate-limits") ||
+		strings.Contains(strings.ToLower(e.Message), "secondary rate limit")
+}
+
+// transient returns true if err, from sending a request, is a timeout or
+// the connection being cut short, which may not happen again. Other
+// errors, such as a bad URL or certificate, are returned straight away.
+func transient(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// jitter returns the wait after the attempt'th try of a request failed
+// with a transient error: backoff, doubled for each attempt after the
+// first, of which up to half is random so that clients backing off
+// together spread out.
+func (rt *retryTransport) jitter(attempt int) time.Duration {
+	d := rt.backoff << (attempt - 1)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)) //nolint:gosec
+}
+
+// record notes the rate limit headers in resp, if it has them.
+func (rt *retryTransport) record(resp *http.Response) {
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	resource := resp.Header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = "core"
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.quota[resource] = quota{limit: limit, remaining: remaining, reset: time.Unix(reset, 0)}
+}
+
+// logQuota logs the remaining rate limit for each resource used.
+func (rt *retryTransport) logQuota() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	parts := []string{}
+	for _, resource := range slices.Sorted(maps.Keys(rt.quota)) {
+		q := rt.quota[resource]
+		parts = append(parts, fmt.Sprintf("%s %d/%d until %s", resource, q.remaining, q.limit, q.reset.Format("15:04:05")))
+	}
+	if len(parts) > 0 {
+		log.Printf("GitHub rate limit remaining: %s", strings.Join(parts, "; "))
+	}
+}
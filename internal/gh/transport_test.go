@@ -0,0 +1,203 @@
+package gh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeResponse is a response for a test server to give.
+type fakeResponse struct {
+	status  int
+	headers map[string]string
+	body    string
+}
+
+// testTransport returns a retryTransport that records how long it's asked
+// to sleep rather than sleeping, and a server that gives responses in turn,
+// followed by 200s.
+func testTransport(t *testing.T, responses ...fakeResponse) (*retryTransport, *[]time.Duration, string) {
+	i := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if i < len(responses) {
+			for k, v := range responses[i].headers {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(responses[i].status)
+			fmt.Fprint(w, responses[i].body)
+		}
+		i++
+	}))
+	t.Cleanup(srv.Close)
+
+	slept := []time.Duration{}
+	rt := newRetryTransport(http.DefaultTransport)
+	rt.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	return rt, &slept, srv.URL
+}
+
+func get(t *testing.T, rt *retryTransport, url string) int {
+	resp, err := (&http.Client{Transport: rt}).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRetryTransportServerError(t *testing.T) {
+	rt, slept, url := testTransport(t,
+		fakeResponse{status: 502},
+		fakeResponse{status: 503},
+	)
+	if status := get(t, rt, url); status != 200 {
+		t.Fatalf("Expected success after retries, got %d", status)
+	}
+	if len(*slept) != 2 {
+		t.Fatalf("Expected 2 waits, got %v", *slept)
+	}
+	for i, d := range *slept {
+		ceiling := rt.backoff << i
+		if d < ceiling/2 || d > ceiling {
+			t.Fatalf("Expected wait %d to be between %s and %s, got %s", i, ceiling/2, ceiling, d)
+		}
+	}
+}
+
+func TestRetryTransportRetryAfter(t *testing.T) {
+	rt, slept, url := testTransport(t,
+		fakeResponse{status: 403, headers: map[string]string{"Retry-After": "7"}},
+	)
+	if status := get(t, rt, url); status != 200 {
+		t.Fatalf("Expected success after retry, got %d", status)
+	}
+	if len(*slept) != 1 || (*slept)[0] != 7*time.Second {
+		t.Fatalf("Expected to wait as long as Retry-After said, got %v", *slept)
+	}
+}
+
+func TestRetryTransportRateLimitReset(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	reset := strconv.FormatInt(now.Add(30*time.Second).Unix(), 10)
+	rt, slept, url := testTransport(t,
+		fakeResponse{status: 403, headers: map[string]string{
+			"X-RateLimit-Limit":     "5000",
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":     reset,
+		}},
+	)
+	rt.now = func() time.Time { return now }
+	if status := get(t, rt, url); status != 200 {
+		t.Fatalf("Expected success after retry, got %d", status)
+	}
+	if len(*slept) != 1 || (*slept)[0] != 31*time.Second {
+		t.Fatalf("Expected to wait until the limit reset, got %v", *slept)
+	}
+}
+
+func TestRetryTransportSecondaryRateLimit(t *testing.T) {
+	rt, slept, url := testTransport(t, fakeResponse{
+		status: 403,
+		body:   `{"message": "You have exceeded a secondary rate limit. Please wait a few minutes before you try again.", "documentation_url": "https://docs.github.com/rest/overview/resources-in-the-rest-api#secondary-rate-limits"}`,
+	})
+	if status := get(t, rt, url); status != 200 {
+		t.Fatalf("Expected success after retry, got %d", status)
+	}
+	if len(*slept) != 1 || (*slept)[0] < time.Minute {
+		t.Fatalf("Expected to wait at least a minute, got %v", *slept)
+	}
+}
+
+func TestRetryTransportGivesUp(t *testing.T) {
+	// A plain 403 is a permissions problem and isn't retried, and its
+	// body is still there to be read.
+	rt, slept, url := testTransport(t, fakeResponse{status: 403, body: `{"message": "Resource not accessible by integration"}`})
+	resp, err := (&http.Client{Transport: rt}).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 403 || len(*slept) != 0 || !strings.Contains(string(body), "not accessible") {
+		t.Fatalf("Expected 403 without retrying, got %d with %q after %v", resp.StatusCode, body, *slept)
+	}
+
+	// Waits beyond the budget aren't made.
+	rt, slept, url = testTransport(t,
+		fakeResponse{status: 429, headers: map[string]string{"Retry-After": "3600"}},
+	)
+	if status := get(t, rt, url); status != 429 || len(*slept) != 0 {
+		t.Fatalf("Expected 429 without retrying, got %d after %v", status, *slept)
+	}
+
+	// Nor are more than maxAttempts requests.
+	responses := []fakeResponse{}
+	for i := 0; i < 10; i++ {
+		responses = append(responses, fakeResponse{status: 500})
+	}
+	rt, slept, url = testTransport(t, responses...)
+	if status := get(t, rt, url); status != 500 || len(*slept) != rt.maxAttempts-1 {
+		t.Fatalf("Expected 500 after %d retries, got %d after %v", rt.maxAttempts-1, status, *slept)
+	}
+}
+
+// failingTransport fails each request with the next of errs, then succeeds
+// with a 200.
+type failingTransport struct {
+	errs []error
+}
+
+func (ft *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(ft.errs) > 0 {
+		err := ft.errs[0]
+		ft.errs = ft.errs[1:]
+		return nil, err
+	}
+	return &http.Response{StatusCode: 200, Body: http.NoBody, Request: req}, nil
+}
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryTransportErrors(t *testing.T) {
+	tests := []struct {
+		err   error
+		retry bool
+	}{
+		{timeoutError{}, true},
+		{io.ErrUnexpectedEOF, true},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{errors.New("x509: certificate signed by unknown authority"), false},
+		{syscall.ECONNREFUSED, false},
+	}
+	for _, tt := range tests {
+		rt := newRetryTransport(&failingTransport{errs: []error{tt.err}})
+		slept := []time.Duration{}
+		rt.sleep = func(_ context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		}
+		_, err := (&http.Client{Transport: rt}).Get("https://api.github.com/user")
+		if tt.retry && (err != nil || len(slept) != 1) {
+			t.Errorf("Expected %v to be retried, got %v after %v", tt.err, err, slept)
+		}
+		if !tt.retry && (err == nil || len(slept) != 0) {
+			t.Errorf("Expected %v to be returned without retrying, got %v after %v", tt.err, err, slept)
+		}
+	}
+}
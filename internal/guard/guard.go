@@ -0,0 +1,87 @@
+// Package guard protects against github2omnifocus completing large numbers
+// of tasks by mistake.
+//
+// If GitHub returns an empty or truncated list, for example because the
+// access token has the wrong scopes or the search API has a hiccup, the
+// delta will remove every task of that kind. Check spots operations that
+// remove more tasks than the user has said is reasonable, so the run can be
+// stopped before any tasks are completed.
+package guard
+
+import (
+	"fmt"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/delta"
+	"github.com/mikerhodes/github-to-omnifocus/internal/omnifocus"
+)
+
+// Limit caps how many tasks of a kind a single run may remove. A zero
+// field is not checked.
+type Limit struct {
+	// MaxRemove is the largest number of tasks that may be removed.
+	MaxRemove int
+	// MaxRemovePercent is the largest percentage of the current tasks
+	// that may be removed.
+	MaxRemovePercent float64
+}
+
+// Violation records that the operations for a kind of task exceed its
+// limit.
+type Violation struct {
+	Kind    omnifocus.Kind
+	Limit   Limit
+	Current int
+	// Removals are the operations that remove tasks of Kind.
+	Removals []omnifocus.Operation
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf(
+		"refusing to remove %d of %d %s tasks; limit is %d tasks or %.0f%%",
+		len(v.Removals), v.Current, v.Kind, v.Limit.MaxRemove, v.Limit.MaxRemovePercent,
+	)
+}
+
+// Check returns a Violation for each kind of task where ops would remove
+// more of the tasks in current than its limit in limits allows.
+func Check(ops []omnifocus.Operation, current []omnifocus.Task, limits map[omnifocus.Kind]Limit) []Violation {
+	counts := map[omnifocus.Kind]int{}
+	for _, t := range current {
+		counts[t.Kind]++
+	}
+	removals := map[omnifocus.Kind][]omnifocus.Operation{}
+	for _, op := range ops {
+		if op.Type == delta.Remove && op.Task != nil {
+			removals[op.Task.Kind] = append(removals[op.Task.Kind], op)
+		}
+	}
+
+	vs := []Violation{}
+	for _, k := range omnifocus.Kinds {
+		l, ok := limits[k]
+		if !ok || len(removals[k]) == 0 {
+			continue
+		}
+		if l.exceeded(len(removals[k]), counts[k]) {
+			vs = append(vs, Violation{
+				Kind:     k,
+				Limit:    l,
+				Current:  counts[k],
+				Removals: removals[k],
+			})
+		}
+	}
+	return vs
+}
+
+// exceeded returns true if removing n of current tasks exceeds l.
+func (l Limit) exceeded(n, current int) bool {
+	if l.MaxRemove > 0 && n > l.MaxRemove {
+		return true
+	}
+	if l.MaxRemovePercent > 0 && current > 0 &&
+		float64(n)*100/float64(current) > l.MaxRemovePercent {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package guard
+
+import (
+	"testing"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/delta"
+	"github.com/mikerhodes/github-to-omnifocus/internal/omnifocus"
+)
+
+func tasks(k omnifocus.Kind, n int) []omnifocus.Task {
+	r := []omnifocus.Task{}
+	for i := 0; i < n; i++ {
+		r = append(r, omnifocus.Task{Kind: k})
+	}
+	return r
+}
+
+func removals(current []omnifocus.Task) []omnifocus.Operation {
+	ops := []omnifocus.Operation{}
+	for _, t := range current {
+		ops = append(ops, omnifocus.Operation{Type: delta.Remove, Task: &t})
+	}
+	return ops
+}
+
+func TestCheckMaxRemove(t *testing.T) {
+	current := tasks(omnifocus.KindReview, 10)
+	limits := map[omnifocus.Kind]Limit{omnifocus.KindReview: {MaxRemove: 5}}
+
+	if vs := Check(removals(current[:5]), current, limits); len(vs) != 0 {
+		t.Fatalf("Expected removing 5 tasks to be allowed, got %v", vs)
+	}
+	vs := Check(removals(current[:6]), current, limits)
+	if len(vs) != 1 {
+		t.Fatalf("Expected removing 6 tasks to be refused, got %v", vs)
+	}
+	if vs[0].Kind != omnifocus.KindReview || len(vs[0].Removals) != 6 || vs[0].Current != 10 {
+		t.Fatalf("Unexpected violation: %+v", vs[0])
+	}
+}
+
+func TestCheckMaxRemovePercent(t *testing.T) {
+	current := tasks(omnifocus.KindAssigned, 4)
+	limits := map[omnifocus.Kind]Limit{omnifocus.KindAssigned: {MaxRemovePercent: 50}}
+
+	if vs := Check(removals(current[:2]), current, limits); len(vs) != 0 {
+		t.Fatalf("Expected removing 50%% to be allowed, got %v", vs)
+	}
+	if vs := Check(removals(current[:3]), current, limits); len(vs) != 1 {
+		t.Fatalf("Expected removing 75%% to be refused, got %v", vs)
+	}
+}
+
+func TestCheckIsPerKind(t *testing.T) {
+	reviews := tasks(omnifocus.KindReview, 2)
+	notifications := tasks(omnifocus.KindNotification, 10)
+	current := append(reviews, notifications...)
+	limits := map[omnifocus.Kind]Limit{omnifocus.KindReview: {MaxRemove: 1}}
+
+	// Notifications have no limit, so removing them all is fine.
+	if vs := Check(removals(notifications), current, limits); len(vs) != 0 {
+		t.Fatalf("Expected unlimited kind to be allowed, got %v", vs)
+	}
+	vs := Check(removals(current), current, limits)
+	if len(vs) != 1 || vs[0].Kind != omnifocus.KindReview {
+		t.Fatalf("Expected only reviews to be refused, got %v", vs)
+	}
+}
@@ -0,0 +1,127 @@
+package omnifocus
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Error codes returned by the JXA scripts, or derived from osascript's
+// output when a script can't be run at all.
+const (
+	CodeProjectNotFound  = "project-not-found"
+	CodeProjectAmbiguous = "project-ambiguous"
+	CodeTaskNotFound     = "task-not-found"
+	CodeAppNotRunning    = "app-not-running"
+	CodePermissionDenied = "permission-denied"
+	CodeScriptFailed     = "script-failed"
+)
+
+// Errors to compare against using errors.Is. Any *ScriptError with the same
+// Code matches.
+var (
+	ErrProjectNotFound  = &ScriptError{Code: CodeProjectNotFound}
+	ErrProjectAmbiguous = &ScriptError{Code: CodeProjectAmbiguous}
+	ErrTaskNotFound     = &ScriptError{Code: CodeTaskNotFound}
+	ErrAppNotRunning    = &ScriptError{Code: CodeAppNotRunning}
+	ErrPermissionDenied = &ScriptError{Code: CodePermissionDenied}
+	ErrScriptFailed     = &ScriptError{Code: CodeScriptFailed}
+)
+
+// ScriptError is returned when a JXA script fails.
+type ScriptError struct {
+	Script  string `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Script, e.Code, e.Message)
+}
+
+// Is allows errors.Is to match a ScriptError against the Err variables by
+// Code.
+func (e *ScriptError) Is(target error) bool {
+	t, ok := target.(*ScriptError)
+	return ok && t.Code == e.Code
+}
+
+// envelope is the JSON document output by every JXA script. When OK is
+// true, Result holds the script's result; otherwise Error says what went
+// wrong.
+type envelope struct {
+	OK     bool            `json:"ok"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *ScriptError    `json:"error,omitempty"`
+}
+
+// runScript runs the script name using r, passing it args marshalled to
+// JSON, and unmarshals the result in the script's envelope into result,
+// which may be nil if the result isn't needed.
+func runScript(r ScriptRunner, name string, args any, result any) error {
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("error marshalling arguments for %s: %w", name, err)
+	}
+
+	out, err := r.RunScript(name, argsJSON)
+	if err != nil {
+		return err
+	}
+
+	env := envelope{}
+	err = json.Unmarshal(out, &env)
+	if err != nil {
+		return &ScriptError{Script: name, Code: CodeScriptFailed, Message: fmt.Sprintf("invalid output %q: %v", out, err)}
+	}
+	err = env.err(name)
+	if err != nil {
+		return err
+	}
+	return env.decode(name, result)
+}
+
+// err returns the error held in e, if any, as coming from the script name.
+func (e envelope) err(name string) error {
+	if e.OK {
+		return nil
+	}
+	if e.Error == nil {
+		return &ScriptError{Script: name, Code: CodeScriptFailed, Message: "no error given"}
+	}
+	se := *e.Error
+	se.Script = name
+	return &se
+}
+
+// decode unmarshals the result held in e into result, which may be nil if
+// the result isn't needed.
+func (e envelope) decode(name string, result any) error {
+	if result == nil || len(e.Result) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(e.Result, result)
+	if err != nil {
+		return &ScriptError{Script: name, Code: CodeScriptFailed, Message: fmt.Sprintf("invalid result %q: %v", e.Result, err)}
+	}
+	return nil
+}
+
+// osascriptError converts the stderr output of a failed osascript run into
+// a ScriptError. Errors that stop the script running at all, rather than
+// errors in the script, are reported this way; osascript includes the
+// Apple Event error number in its message.
+func osascriptError(name string, stderr []byte, err error) *ScriptError {
+	msg := strings.TrimSpace(string(stderr))
+	if msg == "" {
+		msg = err.Error()
+	}
+	code := CodeScriptFailed
+	switch {
+	case strings.Contains(msg, "(-600)"):
+		code = CodeAppNotRunning
+	case strings.Contains(msg, "(-1743)"):
+		code = CodePermissionDenied
+	}
+	return &ScriptError{Script: name, Code: code, Message: msg}
+}
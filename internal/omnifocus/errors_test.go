@@ -0,0 +1,67 @@
+package omnifocus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/delta"
+	"github.com/mikerhodes/github-to-omnifocus/internal/gh"
+)
+
+func TestCompleteMissingTask(t *testing.T) {
+	og, _ := newFakeGateway()
+	err := og.Apply(Operation{Type: delta.Remove, Task: &Task{ID: "missing"}})
+	if !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("Expected ErrTaskNotFound, got %v", err)
+	}
+	var se *ScriptError
+	if !errors.As(err, &se) || se.Script != "ofbatch.js" {
+		t.Fatalf("Expected ScriptError from ofbatch.js, got %#v", err)
+	}
+}
+
+func TestAddToMissingProject(t *testing.T) {
+	og, _ := newFakeGateway()
+	og.AssignedProject = "Missing"
+	d := og.DesiredIssues([]gh.GitHubItem{{Title: "foo", HTMLURL: "https://example.com", K: "a/b#1"}})[0]
+	err := og.Apply(Operation{Type: delta.Add, Desired: &d})
+	if !errors.Is(err, ErrProjectNotFound) {
+		t.Fatalf("Expected ErrProjectNotFound, got %v", err)
+	}
+	if errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("Error matched the wrong code: %v", err)
+	}
+}
+
+type outputRunner []byte
+
+func (r outputRunner) RunScript(name string, args []byte) ([]byte, error) {
+	return r, nil
+}
+
+func TestRunScriptBadOutput(t *testing.T) {
+	for _, out := range []string{"", "true", `{"ok": false}`} {
+		err := runScript(outputRunner(out), "ofinbox.js", nil, nil)
+		if !errors.Is(err, ErrScriptFailed) {
+			t.Errorf("Output %q: expected ErrScriptFailed, got %v", out, err)
+		}
+	}
+}
+
+func TestOsascriptError(t *testing.T) {
+	cases := []struct {
+		stderr string
+		want   error
+	}{
+		{"execution error: Error: Error: Application isn't running. (-600)", ErrAppNotRunning},
+		{"execution error: Error: Error: Not authorized to send Apple events to OmniFocus. (-1743)", ErrPermissionDenied},
+		{"execution error: Error: SyntaxError: Unexpected token (-2700)", ErrScriptFailed},
+		{"", ErrScriptFailed},
+	}
+	for _, c := range cases {
+		err := osascriptError("ofinbox.js", []byte(c.stderr), errors.New("exit status 1"))
+		if !errors.Is(err, c.want) {
+			t.Errorf("stderr %q: expected %v, got %v", c.stderr, c.want, err)
+		}
+	}
+}
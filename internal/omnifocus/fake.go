@@ -0,0 +1,432 @@
+package omnifocus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeTask is a task held by a FakeRunner.
+type FakeTask struct {
+	ID          string
+	ProjectName string // empty for inbox tasks
+	// AssignedProject is the project an inbox task moves to when the
+	// inbox is cleaned up.
+	AssignedProject string
+	Name            string
+	Note            string
+	Tags            []string
+	DueDateMS       int64
+	DeferDateMS     int64
+	Flagged         bool
+	CreatedMS       int64
+	Completed       bool
+	CompletedMS     int64
+	Dropped         bool
+}
+
+// FakeRunner is an in-memory ScriptRunner. It understands the same JSON
+// arguments and output as the embedded JXA scripts, allowing the Gateway
+// to be used without Omnifocus, for example in tests or on Linux.
+type FakeRunner struct {
+	mu       sync.Mutex
+	projects []string
+	tags     []string
+	tasks    []*FakeTask
+	nextID   int
+	// Scripts records the name of each script run, in order.
+	Scripts []string
+}
+
+// NewFakeRunner returns a FakeRunner holding the projects in projects.
+func NewFakeRunner(projects ...string) *FakeRunner {
+	return &FakeRunner{projects: projects}
+}
+
+// AddTask adds t to the fake Omnifocus, assigning it an ID and creation
+// time if they are not set, and returns the ID.
+func (f *FakeRunner) AddTask(t FakeTask) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addTask(t)
+}
+
+// Tasks returns a copy of every task held by f, including completed tasks.
+func (f *FakeRunner) Tasks() []FakeTask {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := []FakeTask{}
+	for _, t := range f.tasks {
+		c := *t
+		c.Tags = slices.Clone(t.Tags)
+		r = append(r, c)
+	}
+	return r
+}
+
+// OpenTasks returns a copy of every task held by f that is neither
+// completed nor dropped.
+func (f *FakeRunner) OpenTasks() []FakeTask {
+	r := []FakeTask{}
+	for _, t := range f.Tasks() {
+		if !t.Completed && !t.Dropped {
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+// RunScript meets the ScriptRunner interface. Like the real scripts, its
+// output is an envelope holding the result or a *ScriptError's code and
+// message.
+func (f *FakeRunner) RunScript(name string, args []byte) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Scripts = append(f.Scripts, name)
+
+	var out any
+	var err error
+	switch name {
+	case "ofmanagedtasks.js":
+		q := ManagedTaskQuery{}
+		if err = json.Unmarshal(args, &q); err == nil {
+			out, err = f.managedTasks(q)
+		}
+	case "ofensureprojects.js":
+		q := struct {
+			Paths []string `json:"paths"`
+		}{}
+		if err = json.Unmarshal(args, &q); err == nil {
+			out, err = f.ensureProjects(q.Paths)
+		}
+	case "ofresolveprojects.js":
+		q := struct {
+			Refs         []string `json:"refs"`
+			AllowMissing bool     `json:"allowMissing"`
+		}{}
+		if err = json.Unmarshal(args, &q); err == nil {
+			out, err = f.resolveProjects(q.Refs, q.AllowMissing)
+		}
+	case "ofbatch.js":
+		b := struct {
+			Operations []BatchOperation `json:"operations"`
+		}{}
+		if err = json.Unmarshal(args, &b); err == nil {
+			out, err = f.batch(b.Operations)
+		}
+	case "ofinbox.js":
+		out = f.inbox()
+	default:
+		return nil, fmt.Errorf("fake: unknown script %s", name)
+	}
+	return fakeEnvelope(out, err)
+}
+
+func (f *FakeRunner) batch(ops []BatchOperation) ([]json.RawMessage, error) {
+	r := []json.RawMessage{}
+	for _, op := range ops {
+		var out any
+		var err error
+		switch {
+		case op.Add != nil:
+			out, err = f.addNewTask(*op.Add)
+		case op.Complete != nil:
+			out, err = f.markTaskComplete(*op.Complete)
+		case op.Drop != nil:
+			out, err = f.markTaskDropped(*op.Drop)
+		case op.Delete != nil:
+			out, err = f.deleteTask(*op.Delete)
+		case op.Update != nil:
+			out, err = f.updateTask(*op.Update)
+		case op.Reopen != nil:
+			out, err = f.reopenTask(*op.Reopen)
+		default:
+			err = &ScriptError{Code: CodeScriptFailed, Message: "empty operation"}
+		}
+		env, err := fakeEnvelope(out, err)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, env)
+	}
+	return r, nil
+}
+
+func fakeEnvelope(out any, err error) ([]byte, error) {
+	if err != nil {
+		se, ok := err.(*ScriptError)
+		if !ok {
+			se = &ScriptError{Code: CodeScriptFailed, Message: err.Error()}
+		}
+		return json.Marshal(envelope{OK: false, Error: se})
+	}
+	result, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{OK: true, Result: result})
+}
+
+type fakeTaskOutput struct {
+	ID          string   `json:"id"`
+	ProjectName string   `json:"projectName,omitempty"`
+	Name        string   `json:"name"`
+	Note        string   `json:"note"`
+	Tags        []string `json:"tags"`
+	DueDateMS   int64    `json:"dueDateMS"`
+	DeferDateMS int64    `json:"deferDateMS,omitempty"`
+	Flagged     bool     `json:"flagged,omitempty"`
+	CreatedMS   int64    `json:"createdMS"`
+	CompletedMS int64    `json:"completedMS,omitempty"`
+}
+
+func (f *FakeRunner) managedTasks(q ManagedTaskQuery) ([]fakeTaskOutput, error) {
+	// Like the real script, tasks are returned with the reference to
+	// their project that we were given.
+	refs := map[string]string{}
+	for _, ref := range q.ProjectNames {
+		project, err := f.project(ref)
+		if err != nil {
+			return nil, err
+		}
+		refs[project] = ref
+	}
+	r := []fakeTaskOutput{}
+	for _, t := range f.tasks {
+		wanted := !t.Completed && !t.Dropped
+		if q.CompletedSinceMS != 0 {
+			wanted = t.Completed && t.CompletedMS >= q.CompletedSinceMS
+		}
+		ref, ok := refs[t.ProjectName]
+		if !wanted || (!ok && !q.Anywhere) || !slices.Contains(t.Tags, q.Tag) {
+			continue
+		}
+		r = append(r, fakeTaskOutput{
+			ID:          t.ID,
+			ProjectName: ref,
+			Name:        t.Name,
+			Note:        t.Note,
+			Tags:        slices.Clone(t.Tags),
+			DueDateMS:   t.DueDateMS,
+			DeferDateMS: t.DeferDateMS,
+			Flagged:     t.Flagged,
+			CreatedMS:   t.CreatedMS,
+			CompletedMS: t.CompletedMS,
+		})
+	}
+	return r, nil
+}
+
+func (f *FakeRunner) markTaskComplete(t Task) (bool, error) {
+	ft := f.task(t.ID)
+	if ft == nil {
+		return false, &ScriptError{Code: CodeTaskNotFound, Message: "task not found: " + t.ID}
+	}
+	ft.Completed = true
+	ft.CompletedMS = time.Now().UnixMilli()
+	return true, nil
+}
+
+func (f *FakeRunner) markTaskDropped(t Task) (bool, error) {
+	ft := f.task(t.ID)
+	if ft == nil {
+		return false, &ScriptError{Code: CodeTaskNotFound, Message: "task not found: " + t.ID}
+	}
+	ft.Dropped = true
+	return true, nil
+}
+
+func (f *FakeRunner) deleteTask(t Task) (bool, error) {
+	if f.task(t.ID) == nil {
+		return false, &ScriptError{Code: CodeTaskNotFound, Message: "task not found: " + t.ID}
+	}
+	f.tasks = slices.DeleteFunc(f.tasks, func(ft *FakeTask) bool {
+		return ft.ID == t.ID
+	})
+	return true, nil
+}
+
+func (f *FakeRunner) ensureTagExists(name string) {
+	if !slices.Contains(f.tags, name) {
+		f.tags = append(f.tags, name)
+	}
+}
+
+// ensureProjects treats each path as a project name; the fake doesn't
+// model folders.
+func (f *FakeRunner) ensureProjects(paths []string) ([]bool, error) {
+	r := []bool{}
+	for _, p := range paths {
+		if strings.HasPrefix(p, ProjectIDPrefix) {
+			if _, err := f.project(p); err != nil {
+				return nil, err
+			}
+			r = append(r, false)
+			continue
+		}
+		// Like the real script, only a project that can't be found is
+		// created, so an ambiguous one is an error.
+		_, err := f.project(p)
+		if err != nil && !errors.Is(err, ErrProjectNotFound) {
+			return nil, err
+		}
+		created := err != nil
+		if created {
+			f.projects = append(f.projects, p)
+		}
+		r = append(r, created)
+	}
+	return r, nil
+}
+
+func (f *FakeRunner) resolveProjects(refs []string, allowMissing bool) ([]*Project, error) {
+	r := []*Project{}
+	for _, ref := range refs {
+		project, err := f.project(ref)
+		if allowMissing && errors.Is(err, ErrProjectNotFound) {
+			r = append(r, nil)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, &Project{ID: project, Name: project})
+	}
+	return r, nil
+}
+
+// project returns the name of the project that ref refers to, following
+// the same rules as projectAt in the JXA scripts. Folders aren't modelled,
+// so a path is treated as a name, and a fake project's ID is its name.
+func (f *FakeRunner) project(ref string) (string, error) {
+	name := strings.TrimPrefix(ref, ProjectIDPrefix)
+	n := 0
+	for _, p := range f.projects {
+		if p == name {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return "", &ScriptError{Code: CodeProjectNotFound, Message: "project not found: " + ref}
+	case n > 1 && name == ref:
+		return "", &ScriptError{Code: CodeProjectAmbiguous, Message: fmt.Sprintf("%s matches %d projects; use a folder path or project ID", ref, n)}
+	}
+	return name, nil
+}
+
+func (f *FakeRunner) addNewTask(t NewOmnifocusTask) (Task, error) {
+	project, err := f.project(t.ProjectName)
+	if err != nil {
+		return Task{}, err
+	}
+	for _, tag := range t.Tags {
+		f.ensureTagExists(tag)
+	}
+	ft := FakeTask{
+		ProjectName: project,
+		Name:        t.Name,
+		Note:        t.Note,
+		Tags:        slices.Clone(t.Tags),
+		DueDateMS:   t.DueDateMS,
+		DeferDateMS: t.DeferDateMS,
+		Flagged:     t.Flagged,
+	}
+	if t.Inbox {
+		ft.ProjectName, ft.AssignedProject = "", project
+	}
+	id := f.addTask(ft)
+	return Task{ID: id, Name: t.Name}, nil
+}
+
+func (f *FakeRunner) updateTask(u TaskUpdate) (bool, error) {
+	t := f.task(u.ID)
+	if t == nil {
+		return false, &ScriptError{Code: CodeTaskNotFound, Message: "task not found: " + u.ID}
+	}
+	project := ""
+	if u.ProjectName != "" {
+		var err error
+		project, err = f.project(u.ProjectName)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	t.Name = u.Name
+	lines := strings.Split(t.Note, "\n")
+	lines[0] = u.URL
+	t.Note = strings.Join(lines, "\n")
+	if u.DueDateMS != 0 {
+		t.DueDateMS = u.DueDateMS
+	}
+	if u.DeferDateMS != 0 {
+		t.DeferDateMS = u.DeferDateMS
+	}
+	if u.Flagged {
+		t.Flagged = true
+	}
+	t.Tags = slices.DeleteFunc(t.Tags, func(tag string) bool {
+		return slices.Contains(u.RemoveTags, tag)
+	})
+	for _, tag := range u.AddTags {
+		f.ensureTagExists(tag)
+		if !slices.Contains(t.Tags, tag) {
+			t.Tags = append(t.Tags, tag)
+		}
+	}
+	if project != "" {
+		t.ProjectName, t.AssignedProject = project, ""
+	}
+	return true, nil
+}
+
+func (f *FakeRunner) reopenTask(u TaskUpdate) (bool, error) {
+	t := f.task(u.ID)
+	if t == nil {
+		return false, &ScriptError{Code: CodeTaskNotFound, Message: "task not found: " + u.ID}
+	}
+	if u.ProjectName != "" {
+		if _, err := f.project(u.ProjectName); err != nil {
+			return false, err
+		}
+	}
+	t.Completed, t.CompletedMS = false, 0
+	return f.updateTask(u)
+}
+
+func (f *FakeRunner) inbox() []Task {
+	r := []Task{}
+	for _, t := range f.tasks {
+		if !t.Completed && !t.Dropped && t.ProjectName == "" {
+			r = append(r, Task{ID: t.ID, Name: t.Name})
+		}
+	}
+	return r
+}
+
+func (f *FakeRunner) addTask(t FakeTask) string {
+	f.nextID++
+	if t.ID == "" {
+		t.ID = fmt.Sprintf("fake%d", f.nextID)
+	}
+	if t.CreatedMS == 0 {
+		t.CreatedMS = int64(f.nextID)
+	}
+	// Like the real script, new tasks go at the top of their project.
+	f.tasks = append([]*FakeTask{&t}, f.tasks...)
+	return t.ID
+}
+
+func (f *FakeRunner) task(id string) *FakeTask {
+	for _, t := range f.tasks {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
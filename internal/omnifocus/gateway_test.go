@@ -0,0 +1,589 @@
+package omnifocus
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/delta"
+	"github.com/mikerhodes/github-to-omnifocus/internal/gh"
+)
+
+func newFakeGateway() (Gateway, *FakeRunner) {
+	f := NewFakeRunner("GitHub Assigned", "GitHub Reviews", "GitHub Notifications")
+	og := Gateway{
+		Runner:               f,
+		AppTag:               "github",
+		AssignedTag:          "assigned",
+		AssignedProject:      "GitHub Assigned",
+		ReviewTag:            "review",
+		ReviewProject:        "GitHub Reviews",
+		NotificationTag:      "notification",
+		NotificationsProject: "GitHub Notifications",
+	}
+	return og, f
+}
+
+var testItem = gh.GitHubItem{
+	Title:   "foo bar",
+	HTMLURL: "https://github.com/mikerhodes/github-to-omnifocus/issues/3",
+	K:       "mikerhodes/github-to-omnifocus#3",
+}
+
+// addTask creates the task of kind k for item, as a sync would.
+func addTask(t *testing.T, og Gateway, k Kind, item gh.GitHubItem) {
+	t.Helper()
+	d := og.desiredTasks(k, []gh.GitHubItem{item})[0]
+	if err := og.Apply(Operation{Type: delta.Add, Desired: &d}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// completeTask completes task, as a sync would once its item has gone.
+func completeTask(t *testing.T, og Gateway, task Task) {
+	t.Helper()
+	if err := og.Apply(Operation{Type: delta.Remove, Task: &task}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGatewayAddIssue(t *testing.T) {
+	og, f := newFakeGateway()
+	addTask(t, og, KindAssigned, testItem)
+
+	tasks, err := og.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("Expected 1 issue, got %v", tasks)
+	}
+	if tasks[0].Key() != testItem.Key() || tasks[0].URL() != testItem.HTMLURL || tasks[0].Kind != KindAssigned {
+		t.Fatalf("Unexpected issue task: %+v", tasks[0])
+	}
+	if ft := f.OpenTasks()[0]; ft.ProjectName != "GitHub Assigned" {
+		t.Fatalf("Expected task in assigned project, got %+v", ft)
+	}
+}
+
+func TestGatewayCompletePR(t *testing.T) {
+	og, f := newFakeGateway()
+	addTask(t, og, KindReview, testItem)
+	prs, err := og.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	completeTask(t, og, prs[0])
+	if open := f.OpenTasks(); len(open) != 0 {
+		t.Fatalf("Expected no open tasks, got %v", open)
+	}
+}
+
+func TestGatewayUpdateTaskKeepsUserNotes(t *testing.T) {
+	og, f := newFakeGateway()
+	f.AddTask(FakeTask{
+		ProjectName: "GitHub Assigned",
+		Name:        "mikerhodes/github-to-omnifocus#3 old title",
+		Note:        "https://example.com/old\nmy own notes",
+		Tags:        []string{"github", "assigned", "someday"},
+	})
+
+	current, err := og.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := delta.Delta(og.DesiredIssues([]gh.GitHubItem{testItem}), current)
+	if len(d.Update) != 1 {
+		t.Fatalf("Expected 1 update, got %+v", d)
+	}
+	if err := og.Apply(Operations(d)[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	ft := f.OpenTasks()[0]
+	if ft.Name != "mikerhodes/github-to-omnifocus#3 foo bar" {
+		t.Fatalf("Task not renamed: %+v", ft)
+	}
+	if ft.Note != testItem.HTMLURL+"\nmy own notes" {
+		t.Fatalf("Task note not rewritten correctly: %q", ft.Note)
+	}
+	if len(ft.Tags) != 3 {
+		t.Fatalf("Expected user's tag to be kept, got %v", ft.Tags)
+	}
+}
+
+func TestGatewayMoveTask(t *testing.T) {
+	og, f := newFakeGateway()
+	addTask(t, og, KindReview, testItem)
+	current, err := og.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := delta.Delta(og.DesiredIssues([]gh.GitHubItem{testItem}), current)
+	if len(d.Move) != 1 {
+		t.Fatalf("Expected 1 move, got %+v", d)
+	}
+	if err := og.Apply(Operations(d)[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	open := f.OpenTasks()
+	if len(open) != 1 {
+		t.Fatalf("Expected the task to be moved, not re-created: %v", open)
+	}
+	if open[0].ProjectName != "GitHub Assigned" {
+		t.Fatalf("Task not moved to assigned project: %+v", open[0])
+	}
+	if !hasAll(open[0].Tags, []string{"github", "assigned"}) || hasAll(open[0].Tags, []string{"review"}) {
+		t.Fatalf("Task type tag not swapped: %v", open[0].Tags)
+	}
+}
+
+// runSync carries out a whole run against og, returning the number of
+// operations applied.
+func runSync(t *testing.T, og Gateway, issues, prs, notifications []gh.GitHubItem) int {
+	t.Helper()
+	current, err := og.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := Operations(delta.Delta(og.Desired(issues, prs, notifications), current))
+	for _, op := range ops {
+		if err := og.Apply(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return len(ops)
+}
+
+func TestGatewaySync(t *testing.T) {
+	og, f := newFakeGateway()
+	pr := gh.GitHubItem{Title: "a PR", HTMLURL: "https://example.com/pr", K: "a/b#1"}
+	notification := gh.GitHubItem{Title: "a comment", HTMLURL: "https://example.com/c", K: "a/b#2"}
+
+	if n := runSync(t, og, nil, []gh.GitHubItem{pr}, []gh.GitHubItem{notification}); n != 2 {
+		t.Fatalf("Expected 2 operations on first run, got %d", n)
+	}
+	if n := runSync(t, og, nil, []gh.GitHubItem{pr}, []gh.GitHubItem{notification}); n != 0 {
+		t.Fatalf("Expected no operations on second run, got %d", n)
+	}
+
+	// The notification is read and the PR is assigned to us.
+	if n := runSync(t, og, []gh.GitHubItem{pr}, nil, nil); n != 2 {
+		t.Fatalf("Expected 2 operations on third run, got %d", n)
+	}
+	open := f.OpenTasks()
+	if len(open) != 1 || open[0].Name != "a/b#1 a PR" || open[0].ProjectName != "GitHub Assigned" {
+		t.Fatalf("Expected only the moved PR to be open, got %v", open)
+	}
+}
+
+func TestGatewayApplyAll(t *testing.T) {
+	og, f := newFakeGateway()
+	existing := f.AddTask(FakeTask{
+		ProjectName: "GitHub Reviews",
+		Name:        "a/b#1 a PR",
+		Note:        "https://example.com/pr",
+		Tags:        []string{"github", "review"},
+	})
+	issue := gh.GitHubItem{Title: "an issue", HTMLURL: "https://example.com/i", K: "a/b#2"}
+	ops := []Operation{
+		{Type: delta.Add, Desired: &og.DesiredIssues([]gh.GitHubItem{issue})[0]},
+		{Type: delta.Remove, Task: &Task{ID: "missing", Kind: KindReview}},
+		{Type: delta.Remove, Task: &Task{ID: existing, Kind: KindReview}},
+	}
+
+	f.Scripts = nil
+	results, err := og.ApplyAll(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Scripts) != 1 || f.Scripts[0] != "ofbatch.js" {
+		t.Fatalf("Expected a single batch script run, got %v", f.Scripts)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %v", results)
+	}
+	if results[0].Err != nil || results[0].TaskID == "" {
+		t.Fatalf("Expected add to succeed with a task ID, got %+v", results[0])
+	}
+	if !errors.Is(results[1].Err, ErrTaskNotFound) {
+		t.Fatalf("Expected ErrTaskNotFound, got %v", results[1].Err)
+	}
+	if results[2].Err != nil {
+		t.Fatalf("Expected the remove after a failure to succeed, got %v", results[2].Err)
+	}
+
+	open := f.OpenTasks()
+	if len(open) != 1 || open[0].ID != results[0].TaskID || open[0].ProjectName != "GitHub Assigned" {
+		t.Fatalf("Expected only the added issue to be open, got %v", open)
+	}
+}
+
+func TestGatewayApplyAllUsesPlannedTask(t *testing.T) {
+	og, f := newFakeGateway()
+	due := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+	og.DueDates = map[Kind]time.Time{KindAssigned: due}
+	issue := gh.GitHubItem{Title: "an issue", HTMLURL: "https://example.com/i", K: "a/b#2"}
+	op := Operation{Type: delta.Add, Desired: &og.DesiredIssues([]gh.GitHubItem{issue})[0]}
+
+	// The config and date change between planning and applying.
+	og.DueDates = map[Kind]time.Time{}
+	og.Flagged = map[Kind]bool{KindAssigned: true}
+
+	if err := og.Apply(op); err != nil {
+		t.Fatal(err)
+	}
+	open := f.OpenTasks()
+	if len(open) != 1 || open[0].DueDateMS != due.UnixMilli() || open[0].Flagged {
+		t.Fatalf("Expected the task to be created as planned, got %+v", open)
+	}
+}
+
+func TestGatewayGetTasksSingleQuery(t *testing.T) {
+	og, f := newFakeGateway()
+	f.AddTask(FakeTask{ProjectName: "GitHub Assigned", Name: "a/b#1 issue", Tags: []string{"github", "assigned"}, Flagged: true})
+	f.AddTask(FakeTask{ProjectName: "GitHub Reviews", Name: "a/b#2 PR", Tags: []string{"github", "review"}, DeferDateMS: 100})
+	// Neither of these are ours: one is in the inbox, the other has the
+	// wrong type tag for its project.
+	f.AddTask(FakeTask{Name: "a/b#3 inbox", Tags: []string{"github", "notification"}})
+	f.AddTask(FakeTask{ProjectName: "GitHub Reviews", Name: "a/b#4 other", Tags: []string{"github", "assigned"}})
+
+	f.Scripts = nil
+	tasks, err := og.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Scripts) != 1 || f.Scripts[0] != "ofmanagedtasks.js" {
+		t.Fatalf("Expected a single query, got %v", f.Scripts)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("Expected 2 tasks, got %v", tasks)
+	}
+	for _, task := range tasks {
+		switch task.Key() {
+		case "a/b#1":
+			if task.Kind != KindAssigned || !task.Flagged || task.ProjectName != "GitHub Assigned" {
+				t.Errorf("Unexpected issue task: %+v", task)
+			}
+		case "a/b#2":
+			if task.Kind != KindReview || task.DeferDateMS != 100 {
+				t.Errorf("Unexpected PR task: %+v", task)
+			}
+		default:
+			t.Errorf("Unexpected task: %+v", task)
+		}
+	}
+}
+
+func TestGatewayEnsureProjects(t *testing.T) {
+	f := NewFakeRunner("GitHub Assigned")
+	og := Gateway{
+		Runner:               f,
+		AppTag:               "github",
+		AssignedTag:          "assigned",
+		AssignedProject:      "GitHub Assigned",
+		ReviewTag:            "review",
+		ReviewProject:        "Work/GitHub",
+		NotificationTag:      "notification",
+		NotificationsProject: "Work/GitHub",
+	}
+	if _, err := og.GetTasks(); !errors.Is(err, ErrProjectNotFound) {
+		t.Fatalf("Expected ErrProjectNotFound, got %v", err)
+	}
+	if err := og.EnsureProjects(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := og.GetTasks(); err != nil {
+		t.Fatal(err)
+	}
+	addTask(t, og, KindReview, testItem)
+	if ft := f.OpenTasks()[0]; ft.ProjectName != "Work/GitHub" {
+		t.Fatalf("Expected task in created project, got %+v", ft)
+	}
+}
+
+func TestGatewayEnsureProjectsAmbiguous(t *testing.T) {
+	og, _ := newFakeGateway()
+	og.Runner = NewFakeRunner("GitHub Assigned", "GitHub Reviews", "GitHub Reviews", "GitHub Notifications")
+	if err := og.EnsureProjects(); !errors.Is(err, ErrProjectAmbiguous) {
+		t.Fatalf("Expected ErrProjectAmbiguous, got %v", err)
+	}
+}
+
+func TestGatewayResolveProjects(t *testing.T) {
+	og, f := newFakeGateway()
+	if _, err := og.ResolveProjects(false); err != nil {
+		t.Fatal(err)
+	}
+	addTask(t, og, KindAssigned, testItem)
+	tasks, err := og.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ProjectName != ProjectIDPrefix+"GitHub Assigned" {
+		t.Fatalf("Expected issue read back by project ID, got %+v", tasks)
+	}
+	if ft := f.OpenTasks()[0]; ft.ProjectName != "GitHub Assigned" {
+		t.Fatalf("Expected task in assigned project, got %+v", ft)
+	}
+}
+
+func TestGatewayResolveProjectsAmbiguous(t *testing.T) {
+	og, _ := newFakeGateway()
+	og.Runner = NewFakeRunner("GitHub Assigned", "GitHub Reviews", "GitHub Reviews", "GitHub Notifications")
+	if _, err := og.ResolveProjects(false); !errors.Is(err, ErrProjectAmbiguous) {
+		t.Fatalf("Expected ErrProjectAmbiguous, got %v", err)
+	}
+}
+
+func TestGatewayResolveProjectsMissing(t *testing.T) {
+	og, _ := newFakeGateway()
+	f := NewFakeRunner("GitHub Assigned", "GitHub Reviews")
+	og.Runner = f
+	if _, err := og.ResolveProjects(false); !errors.Is(err, ErrProjectNotFound) {
+		t.Fatalf("Expected ErrProjectNotFound, got %v", err)
+	}
+
+	missing, err := og.ResolveProjects(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(missing, []string{"GitHub Notifications"}) {
+		t.Fatalf("Expected notifications project to be missing, got %v", missing)
+	}
+	if _, err := og.GetTasks(); err != nil {
+		t.Fatalf("Expected tasks to be read without the missing project, got %v", err)
+	}
+	if n := len(f.projects); n != 2 {
+		t.Fatalf("Expected no projects to be created, got %d", n)
+	}
+}
+
+func TestGatewayInbox(t *testing.T) {
+	og, f := newFakeGateway()
+	og.Inbox = map[Kind]bool{KindReview: true}
+	pr := gh.GitHubItem{Title: "a PR", HTMLURL: "https://example.com/pr", K: "a/b#1"}
+
+	if n := runSync(t, og, nil, []gh.GitHubItem{pr}, nil); n != 1 {
+		t.Fatalf("Expected 1 operation on first run, got %d", n)
+	}
+	ft := f.OpenTasks()[0]
+	if ft.ProjectName != "" || ft.AssignedProject != "GitHub Reviews" {
+		t.Fatalf("Expected task in inbox assigned to reviews project, got %+v", ft)
+	}
+	if n := runSync(t, og, nil, []gh.GitHubItem{pr}, nil); n != 0 {
+		t.Fatalf("Expected inbox task to be found, got %d operations", n)
+	}
+
+	// The user files the task in a project of their own.
+	f.projects = append(f.projects, "Sprint 42")
+	f.task(ft.ID).ProjectName = "Sprint 42"
+	if n := runSync(t, og, nil, []gh.GitHubItem{pr}, nil); n != 0 {
+		t.Fatalf("Expected filed task to be found, got %d operations", n)
+	}
+	if n := runSync(t, og, nil, nil, nil); n != 1 {
+		t.Fatalf("Expected filed task to be completed, got %d operations", n)
+	}
+	if open := f.OpenTasks(); len(open) != 0 {
+		t.Fatalf("Expected no open tasks, got %v", open)
+	}
+}
+
+func TestGatewayFindAnywhere(t *testing.T) {
+	og, f := newFakeGateway()
+	f.projects = append(f.projects, "Sprint 42")
+	pr := gh.GitHubItem{Title: "a PR", HTMLURL: "https://example.com/pr", K: "a/b#1"}
+	runSync(t, og, nil, []gh.GitHubItem{pr}, nil)
+	id := f.OpenTasks()[0].ID
+	f.task(id).ProjectName = "Sprint 42"
+
+	// Without FindAnywhere, the filed task isn't found so is re-created.
+	if n := runSync(t, og, nil, []gh.GitHubItem{pr}, nil); n != 1 || len(f.OpenTasks()) != 2 {
+		t.Fatalf("Expected the task to be re-created, got %d operations", n)
+	}
+	f.task(id).Completed = true
+	runSync(t, og, nil, nil, nil)
+
+	og.Anywhere = map[Kind]bool{KindAssigned: true, KindReview: true}
+	runSync(t, og, nil, []gh.GitHubItem{pr}, nil)
+	id = f.OpenTasks()[0].ID
+	f.task(id).ProjectName = "Sprint 42"
+	if n := runSync(t, og, nil, []gh.GitHubItem{pr}, nil); n != 0 {
+		t.Fatalf("Expected filed task to be found, got %d operations", n)
+	}
+
+	// When the PR is assigned to us, the task changes kind but stays put.
+	if n := runSync(t, og, []gh.GitHubItem{pr}, nil, nil); n != 1 {
+		t.Fatalf("Expected 1 move, got %d operations", n)
+	}
+	ft := f.OpenTasks()[0]
+	if ft.ProjectName != "Sprint 42" || !hasAll(ft.Tags, []string{"github", "assigned"}) {
+		t.Fatalf("Expected assigned task left in Sprint 42, got %+v", ft)
+	}
+
+	if n := runSync(t, og, nil, nil, nil); n != 1 || len(f.OpenTasks()) != 0 {
+		t.Fatalf("Expected filed task to be completed, got %d operations", n)
+	}
+}
+
+func TestGatewayReopen(t *testing.T) {
+	og, f := newFakeGateway()
+	addTask(t, og, KindAssigned, testItem)
+	ft := f.OpenTasks()[0]
+	f.task(ft.ID).Note = testItem.HTMLURL + "\nmy notes"
+	completeTask(t, og, Task{ID: ft.ID})
+
+	// Without a window, nothing is reopened.
+	completed, err := og.GetCompletedTasks(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(completed) != 0 {
+		t.Fatalf("Expected no completed tasks, got %v", completed)
+	}
+
+	// The item comes back as a PR to review.
+	og.ReopenWithin = map[Kind]time.Duration{KindAssigned: time.Hour}
+	completed, err = og.GetCompletedTasks(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := delta.Delta(og.DesiredPRs([]gh.GitHubItem{testItem}), []Task{})
+	ops := ReopenCompleted(Operations(d), completed)
+	if len(ops) != 1 || ops[0].Type != delta.Reopen || ops[0].Task.ID != ft.ID {
+		t.Fatalf("Expected add to become a reopen of %s, got %v", ft.ID, ops)
+	}
+	if err := og.Apply(ops[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	open := f.OpenTasks()
+	if len(open) != 1 || open[0].ID != ft.ID {
+		t.Fatalf("Expected the completed task to be reopened, got %v", open)
+	}
+	if open[0].ProjectName != "GitHub Reviews" || !hasAll(open[0].Tags, []string{"github", "review"}) || hasAll(open[0].Tags, []string{"assigned"}) {
+		t.Fatalf("Reopened task not moved to reviews: %+v", open[0])
+	}
+	if open[0].Note != testItem.HTMLURL+"\nmy notes" {
+		t.Fatalf("Reopened task lost its notes: %q", open[0].Note)
+	}
+}
+
+func TestReopenCompletedOutsideWindow(t *testing.T) {
+	og, f := newFakeGateway()
+	f.AddTask(FakeTask{
+		ProjectName: "GitHub Assigned",
+		Name:        "a/b#1 old",
+		Tags:        []string{"github", "assigned"},
+		Completed:   true,
+		CompletedMS: time.Now().Add(-2 * time.Hour).UnixMilli(),
+	})
+	og.ReopenWithin = map[Kind]time.Duration{KindAssigned: time.Hour, KindReview: 3 * time.Hour}
+	completed, err := og.GetCompletedTasks(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(completed) != 0 {
+		t.Fatalf("Expected task completed outside its kind's window to be ignored, got %v", completed)
+	}
+}
+
+func TestGatewayRemoveDispositions(t *testing.T) {
+	og, f := newFakeGateway()
+	dropped := f.AddTask(FakeTask{
+		ProjectName: "GitHub Notifications",
+		Name:        "a/b#1 read elsewhere",
+		Tags:        []string{"github", "notification"},
+	})
+	deleted := f.AddTask(FakeTask{
+		ProjectName: "GitHub Notifications",
+		Name:        "a/b#2 also read elsewhere",
+		Tags:        []string{"github", "notification"},
+	})
+	results, err := og.ApplyAll([]Operation{
+		{Type: delta.Remove, Task: &Task{ID: dropped, Kind: KindNotification}, Disposition: DispositionDrop},
+		{Type: delta.Remove, Task: &Task{ID: deleted, Kind: KindNotification}, Disposition: DispositionDelete},
+		{Type: delta.Remove, Task: &Task{ID: dropped, Kind: KindNotification}, Disposition: "archive"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("Expected drop and delete to succeed, got %+v", results)
+	}
+	if results[2].Err == nil {
+		t.Fatalf("Expected an unknown disposition to fail")
+	}
+
+	if ft := f.task(dropped); ft == nil || !ft.Dropped || ft.Completed {
+		t.Fatalf("Expected task to be dropped, not completed, got %+v", ft)
+	}
+	if f.task(deleted) != nil {
+		t.Fatalf("Expected task to be deleted")
+	}
+	tasks, err := og.GetTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 0 {
+		t.Fatalf("Expected dropped task not to be returned, got %v", tasks)
+	}
+}
+
+func TestGatewayKeepsClearedDueDate(t *testing.T) {
+	og, f := newFakeGateway()
+	due := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+	og.DueDates = map[Kind]time.Time{KindNotification: due, KindAssigned: due}
+	item := gh.GitHubItem{Title: "a comment", HTMLURL: "https://example.com/c", K: "a/b#2"}
+
+	if n := runSync(t, og, nil, nil, []gh.GitHubItem{item}); n != 1 {
+		t.Fatalf("Expected 1 operation on first run, got %d", n)
+	}
+	// The user clears the task's due date.
+	f.tasks[0].DueDateMS = 0
+	if n := runSync(t, og, nil, nil, []gh.GitHubItem{item}); n != 0 {
+		t.Fatalf("Expected the cleared due date to be left alone, got %d operations", n)
+	}
+
+	// Moving the task to another kind gives it that kind's due date.
+	if n := runSync(t, og, []gh.GitHubItem{item}, nil, nil); n != 1 {
+		t.Fatalf("Expected the task to be moved, got %d operations", n)
+	}
+	if ft := f.OpenTasks()[0]; ft.DueDateMS != due.UnixMilli() {
+		t.Fatalf("Expected moved task to be given a due date, got %+v", ft)
+	}
+}
+
+func TestGatewayDatesAndFlag(t *testing.T) {
+	og, f := newFakeGateway()
+	due := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+	deferDate := time.Date(2024, 3, 29, 9, 0, 0, 0, time.UTC)
+	og.DueDates = map[Kind]time.Time{KindReview: due}
+	og.DeferDates = map[Kind]time.Time{KindReview: deferDate}
+	og.Flagged = map[Kind]bool{KindReview: true}
+
+	addTask(t, og, KindReview, testItem)
+	addTask(t, og, KindAssigned, gh.GitHubItem{Title: "an issue", HTMLURL: "https://example.com/i", K: "a/b#2"})
+
+	for _, ft := range f.Tasks() {
+		if slices.Contains(ft.Tags, "review") {
+			if ft.DueDateMS != due.UnixMilli() || ft.DeferDateMS != deferDate.UnixMilli() || !ft.Flagged {
+				t.Fatalf("Expected review task to be due, deferred and flagged, got %+v", ft)
+			}
+		} else if ft.DueDateMS != 0 || ft.DeferDateMS != 0 || ft.Flagged {
+			t.Fatalf("Expected assigned task to have no dates or flag, got %+v", ft)
+		}
+	}
+}
+
+// hasAll returns true if tags contains every tag in want.
+func hasAll(tags, want []string) bool {
+	for _, w := range want {
+		if !slices.Contains(tags, w) {
+			return false
+		}
+	}
+	return true
+}
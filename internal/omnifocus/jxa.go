@@ -1,112 +1,160 @@
 package omnifocus
 
 import (
-	"encoding/json"
-	"io"
-	"log"
+	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
+	"slices"
 )
 
 // This file holds the wrapper functions for our JXA scripts
 
-// TasksForQuery returns a list of tasks from Omnifocus that
-// match the passed query.
-func TasksForQuery(q TaskQuery) ([]Task, error) {
-	jsCode, _ := jxa.ReadFile("jxa/oftasksforprojectwithtag.js")
-	args, _ := json.Marshal(q)
+// DefaultOsascriptPath is where osascript lives on macOS.
+const DefaultOsascriptPath = "/usr/bin/osascript"
 
-	out, err := executeScript(jsCode, args)
+// ScriptRunner runs one of the embedded JXA scripts, identified by its file
+// name within the jxa directory, such as "ofbatch.js". args is the
+// JSON document passed to the script. The script's output, a JSON envelope
+// holding either its result or an error, is returned.
+type ScriptRunner interface {
+	RunScript(name string, args []byte) ([]byte, error)
+}
+
+// OsascriptRunner is a ScriptRunner that runs scripts in Omnifocus using
+// osascript.
+type OsascriptRunner struct {
+	// Path is the path to osascript. If empty, DefaultOsascriptPath is used.
+	Path string
+}
+
+// commonScript holds the helpers shared by the scripts, which is put in
+// front of each script that is run.
+const commonScript = "common.js"
+
+// RunScript meets the ScriptRunner interface. If osascript can't run the
+// script, a *ScriptError is returned.
+func (r OsascriptRunner) RunScript(name string, args []byte) ([]byte, error) {
+	if name == commonScript {
+		return nil, fmt.Errorf("%s can't be run on its own", name)
+	}
+	script, err := jxa.ReadFile("jxa/" + name)
 	if err != nil {
-		return []Task{}, err
+		return nil, fmt.Errorf("unknown script %s: %v", name, err)
+	}
+	common, err := jxa.ReadFile("jxa/" + commonScript)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", commonScript, err)
 	}
+	jsCode := slices.Concat(common, []byte("\n"), script)
+	path := r.Path
+	if path == "" {
+		path = DefaultOsascriptPath
+	}
+	out, stderr, err := executeScript(path, jsCode, args)
+	if err != nil {
+		return nil, osascriptError(name, stderr, err)
+	}
+	return out, nil
+}
 
+// ManagedTasks returns the incomplete tasks from Omnifocus that match the
+// passed query, using a single script run.
+func ManagedTasks(r ScriptRunner, q ManagedTaskQuery) ([]Task, error) {
 	tasks := []Task{}
-	err = json.Unmarshal(out, &tasks)
+	err := runScript(r, "ofmanagedtasks.js", q, &tasks)
 	if err != nil {
 		return []Task{}, err
 	}
-
 	return tasks, nil
 }
 
-// MarkOmnifocusTaskComplete marks a task as complete. t only requires the
-// id field to be set.
-func MarkOmnifocusTaskComplete(t Task) error {
-	jsCode, _ := jxa.ReadFile("jxa/ofmarktaskcomplete.js")
-	args, _ := json.Marshal(t)
-
-	_, err := executeScript(jsCode, args)
+// EnsureProjectsExist creates the projects at paths in Omnifocus, along
+// with any folders on the way to them, if they can't be found as
+// ResolveProjects would. It returns whether each project was created.
+func EnsureProjectsExist(r ScriptRunner, paths []string) ([]bool, error) {
+	args := struct {
+		Paths []string `json:"paths"`
+	}{paths}
+	created := []bool{}
+	err := runScript(r, "ofensureprojects.js", args, &created)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
-	return nil
+	return created, nil
 }
 
-// EnsureTagExists creates a tag in Omnifocus if it doesn't already exist.
-func EnsureTagExists(tag Tag) error {
-	jsCode, _ := jxa.ReadFile("jxa/ofensuretagexists.js")
-	args, _ := json.Marshal(tag)
-
-	_, err := executeScript(jsCode, args)
+// ResolveProjects looks up the projects that refs refer to, returning them
+// in the same order. Each ref is "id:" followed by a project ID, a project
+// name or a "Folder/Subfolder/Project" path; a ref containing "/" is tried
+// as a name before a path. It's an error for a ref to match more than one
+// project. If allowMissing is true, a project that can't be found is
+// returned with an empty ID rather than being an error.
+func ResolveProjects(r ScriptRunner, refs []string, allowMissing bool) ([]Project, error) {
+	args := struct {
+		Refs         []string `json:"refs"`
+		AllowMissing bool     `json:"allowMissing,omitempty"`
+	}{refs, allowMissing}
+	projects := []Project{}
+	err := runScript(r, "ofresolveprojects.js", args, &projects)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
-	return nil
+	if len(projects) != len(refs) {
+		return nil, &ScriptError{Script: "ofresolveprojects.js", Code: CodeScriptFailed, Message: fmt.Sprintf("expected %d projects, got %d", len(refs), len(projects))}
+	}
+	return projects, nil
 }
 
-// AddNewOmnifocusTask adds a new Omnifocus task
-func AddNewOmnifocusTask(t NewOmnifocusTask) (Task, error) {
-	jsCode, _ := jxa.ReadFile("jxa/ofaddnewtask.js")
-	args, _ := json.Marshal(t)
-
-	out, err := executeScript(jsCode, args)
+// RunBatch carries out ops in a single script run, returning the result of
+// each operation in the same order. An operation failing doesn't stop the
+// rest being carried out; an error is only returned if the script can't
+// be run at all.
+func RunBatch(r ScriptRunner, ops []BatchOperation) ([]BatchResult, error) {
+	args := struct {
+		Operations []BatchOperation `json:"operations"`
+	}{ops}
+	envs := []envelope{}
+	err := runScript(r, "ofbatch.js", args, &envs)
 	if err != nil {
-		return Task{}, err
+		return nil, err
 	}
-
-	task := Task{}
-	err = json.Unmarshal(out, &task)
-	if err != nil {
-		return Task{}, err
+	if len(envs) != len(ops) {
+		return nil, &ScriptError{Script: "ofbatch.js", Code: CodeScriptFailed, Message: fmt.Sprintf("expected %d results, got %d", len(ops), len(envs))}
 	}
 
-	return task, nil
+	results := make([]BatchResult, len(envs))
+	for i, env := range envs {
+		results[i].Err = env.err("ofbatch.js")
+		if results[i].Err == nil && ops[i].Add != nil {
+			results[i].Err = env.decode("ofbatch.js", &results[i].Task)
+		}
+	}
+	return results, nil
 }
 
-// executeScript runs jsCode passing it args as input, and returns the
-// output of the command.
-func executeScript(jsCode []byte, args []byte) ([]byte, error) {
+// executeScript runs jsCode using the osascript at path, passing it args as
+// input, and returns the stdout and stderr output of the command.
+func executeScript(path string, jsCode []byte, args []byte) ([]byte, []byte, error) {
 	// All scripts expect a JSON object passed in via the
 	// OSA_ARGS environment variable. The script itself is
 	// passed into osascript via stdin. The script outputs
 	// a JSON document over stdout.
 
-	cmd := exec.Command("/usr/bin/osascript", "-l", "JavaScript")
+	cmd := exec.Command(path, "-l", "JavaScript") //nolint:gosec
 
 	cmd.Env = append(os.Environ(),
 		"OSA_ARGS="+string(args),
 	)
+	cmd.Stdin = bytes.NewReader(jsCode)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-	go func() {
-		defer stdin.Close()
-		_, err := io.WriteString(stdin, string(jsCode))
-		if err != nil {
-			// should never fail
-			log.Fatal(err)
-		}
-	}()
-
-	out, err := cmd.Output()
+	err := cmd.Run()
 	if err != nil {
-		return nil, err
+		return nil, stderr.Bytes(), err
 	}
 
-	return out, nil
+	return stdout.Bytes(), stderr.Bytes(), nil
 }
@@ -0,0 +1,66 @@
+// Helpers shared by the other scripts. OsascriptRunner puts this file in
+// front of each script it runs, so to run a script by hand, do the same:
+//   cat common.js ofinbox.js | osascript -l JavaScript | jq .
+
+// projectAt returns the project ref refers to. ref is either "id:" followed
+// by the project's ID, the name of a project anywhere in the document, or a
+// "Folder/Subfolder/Project" path from the top level. A ref containing "/"
+// is first looked up as a name, so projects with "/" in their names can
+// still be used. It's an error for a name or path to match more than one
+// project.
+function projectAt(ofDoc, ref) {
+    if (ref.startsWith("id:")) {
+        const byID = ofDoc.flattenedProjects.whose({ id: ref.slice(3) })
+        if (byID.length === 0) {
+            throw scriptError("project-not-found", "project not found: " + ref)
+        }
+        return byID[0]
+    }
+    var projects = ofDoc.flattenedProjects.whose({ name: ref })
+    const parts = ref.split("/")
+    const name = parts.pop()
+    if (projects.length === 0 && parts.length > 0) {
+        var container = ofDoc
+        for (var i = 0; i < parts.length; i++) {
+            const path = parts.slice(0, i + 1).join("/")
+            const folders = container.folders.whose({ name: parts[i] })
+            if (folders.length === 0) {
+                throw scriptError("project-not-found", "folder not found: " + path)
+            }
+            if (folders.length > 1) {
+                throw scriptError("project-ambiguous", "more than one folder at " + path)
+            }
+            container = folders[0]
+        }
+        projects = container.projects.whose({ name: name })
+    }
+    if (projects.length === 0) {
+        throw scriptError("project-not-found", "project not found: " + ref)
+    }
+    if (projects.length > 1) {
+        throw scriptError("project-ambiguous", ref + " matches " + projects.length + " projects; use a folder path or project ID")
+    }
+    return projects[0]
+}
+
+// envelope calls f and wraps its result, or the error it throws, in the JSON
+// envelope read by runScript in errors.go.
+function envelope(f) {
+    try {
+        return { "ok": true, "result": f() }
+    } catch (e) {
+        var code = e.code || "script-failed"
+        if (e.errorNumber === -600) {
+            code = "app-not-running"
+        } else if (e.errorNumber === -1743) {
+            code = "permission-denied"
+        }
+        return { "ok": false, "error": { "code": code, "message": e.message } }
+    }
+}
+
+function scriptError(code, message) {
+    const e = new Error(message)
+    e.code = code
+    return e
+}
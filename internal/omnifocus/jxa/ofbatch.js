@@ -0,0 +1,232 @@
+// Carry out a list of writes to OmniFocus in one go, looking up each
+// project and tag only once.
+// Accepts a Batch as JSON in an OSA_ARGS env var.
+// Call it:
+//   set -gx OSA_ARGS '{"operations": [{"add": {"projectName": "GitHub Reviews", "name": "task title", "tags": ["github"], "note": "a note", "dueDateMS": 0}}, {"complete": {"id": "a2g4XFUiQKm"}}]}'
+//   cat common.js ofbatch.js | osascript -l JavaScript | jq .
+// Returns JSON with one envelope per operation, in order:
+// {"ok": true, "result": [
+//     {"ok": true, "result": {"id": "k9TCngde98W", "name": "task title"}},
+//     {"ok": false, "error": {"code": "task-not-found", "message": "..."}}
+// ]}
+// Drop and delete operations take a task, like complete, and drop or
+// delete it rather than completing it. A reopen operation takes a
+// TaskUpdate, like update, and marks the task incomplete before updating
+// it.
+// A failing operation doesn't stop the others being carried out.
+
+/**
+ * @typedef {Object} NewOmnifocusTask
+ * @property {string} projectName
+ * @property {boolean} [inbox]
+ * @property {string} name
+ * @property {string[]} tags
+ * @property {string} note
+ * @property {integer} dueDateMS
+ * @property {integer} [deferDateMS]
+ * @property {boolean} [flagged]
+ */
+
+/**
+ * @typedef {Object} OmnifocusTask
+ * @property {string} id
+ */
+
+/**
+ * @typedef {Object} TaskUpdate
+ * @property {string} id
+ * @property {string} [projectName]
+ * @property {string} name
+ * @property {string} url
+ * @property {string[]} addTags
+ * @property {string[]} removeTags
+ * @property {integer} dueDateMS
+ * @property {integer} [deferDateMS]
+ * @property {boolean} [flagged]
+ */
+
+/**
+ * @typedef {Object} BatchOperation
+ * @property {NewOmnifocusTask} [add]
+ * @property {OmnifocusTask} [complete]
+ * @property {OmnifocusTask} [drop]
+ * @property {OmnifocusTask} [delete]
+ * @property {TaskUpdate} [update]
+ * @property {TaskUpdate} [reopen]
+ */
+
+/**
+ * @typedef {Object} Batch
+ * @property {BatchOperation[]} operations
+ */
+
+function batch(
+    /** @type {Batch} */ b
+) {
+    // @ts-ignore
+    const ofApp = Application("OmniFocus")
+    const ofDoc = ofApp.defaultDocument
+
+    const projects = {}
+    const projectNamed = name => {
+        if (!(name in projects)) {
+            projects[name] = projectAt(ofDoc, name)
+        }
+        return projects[name]
+    }
+
+    const tags = {}
+    const tagFoundOrCreated = name => {
+        if (!(name in tags)) {
+            const found = ofDoc.flattenedTags.whose({ name: name })
+            if (found.length === 0) {
+                const oTag = ofApp.Tag({ name: name })
+                ofDoc.tags.push(oTag)
+                tags[name] = oTag
+            } else {
+                tags[name] = found()[0]
+            }
+        }
+        return tags[name]
+    }
+
+    const taskWithID = id => {
+        const found = ofDoc.flattenedTasks.whose({ id: id })
+        if (found.length === 0) {
+            throw scriptError("task-not-found", "task not found: " + id)
+        }
+        return found[0]
+    }
+
+    const add = (/** @type {NewOmnifocusTask} */ t) => {
+        const project = projectNamed(t.projectName)
+        var dueDate = null
+        if (t.dueDateMS) {
+            dueDate = new Date(t.dueDateMS)
+        }
+        var deferDate = null
+        if (t.deferDateMS) {
+            deferDate = new Date(t.deferDateMS)
+        }
+        const task = ofApp.Task({
+            "name": t.name,
+            "note": t.note,
+            "dueDate": dueDate,
+            "deferDate": deferDate,
+            "flagged": !!t.flagged,
+        })
+        if (t.inbox) {
+            // Assigning the project means the task moves there when the
+            // user cleans up the inbox.
+            ofDoc.inboxTasks.push(task)
+            task.assignedContainer = project
+        } else {
+            project.tasks.unshift(task)
+        }
+        t.tags.forEach((name) => {
+            ofApp.add(tagFoundOrCreated(name), {
+                to: task.tags
+            })
+        })
+        return { "id": task.id(), "name": task.name() }
+    }
+
+    const complete = (/** @type {OmnifocusTask} */ t) => {
+        // @ts-ignore
+        ofApp.markComplete(taskWithID(t.id))
+        return true
+    }
+
+    const drop = (/** @type {OmnifocusTask} */ t) => {
+        // @ts-ignore
+        ofApp.markDropped(taskWithID(t.id))
+        return true
+    }
+
+    const remove = (/** @type {OmnifocusTask} */ t) => {
+        // @ts-ignore
+        ofApp.delete(taskWithID(t.id))
+        return true
+    }
+
+    const update = (/** @type {TaskUpdate} */ u) => {
+        const task = taskWithID(u.id)
+        // Look up the project first so we don't half-update the task if
+        // it's missing.
+        const project = u.projectName ? projectNamed(u.projectName) : null
+
+        task.name = u.name
+
+        // The URL lives on the first line of the note; keep anything the
+        // user has written below it.
+        const lines = task.note().split("\n")
+        lines[0] = u.url
+        task.note = lines.join("\n")
+
+        if (u.dueDateMS) {
+            task.dueDate = new Date(u.dueDateMS)
+        }
+        if (u.deferDateMS) {
+            task.deferDate = new Date(u.deferDateMS)
+        }
+        if (u.flagged) {
+            task.flagged = true
+        }
+
+        u.removeTags.forEach((name) => {
+            const tag = task.tags().find(tag => tag.name() == name)
+            if (tag) {
+                ofApp.remove(tag, {
+                    from: task.tags
+                })
+            }
+        })
+        u.addTags.forEach((name) => {
+            if (!task.tags().some(tag => tag.name() == name)) {
+                ofApp.add(tagFoundOrCreated(name), {
+                    to: task.tags
+                })
+            }
+        })
+
+        if (project) {
+            ofApp.move(task, {
+                to: project.tasks.beginning
+            })
+        }
+        return true
+    }
+
+    const reopen = (/** @type {TaskUpdate} */ u) => {
+        const task = taskWithID(u.id)
+        // Look up the project first so we don't reopen the task and then
+        // fail to update it.
+        if (u.projectName) {
+            projectNamed(u.projectName)
+        }
+        // @ts-ignore
+        ofApp.markIncomplete(task)
+        return update(u)
+    }
+
+    return b.operations.map((op) => envelope(() => {
+        if (op.add) {
+            return add(op.add)
+        } else if (op.complete) {
+            return complete(op.complete)
+        } else if (op.drop) {
+            return drop(op.drop)
+        } else if (op.delete) {
+            return remove(op.delete)
+        } else if (op.update) {
+            return update(op.update)
+        } else if (op.reopen) {
+            return reopen(op.reopen)
+        }
+        throw scriptError("script-failed", "empty operation")
+    }))
+}
+
+ObjC.import('stdlib')
+var args = JSON.parse($.getenv('OSA_ARGS'))
+JSON.stringify(envelope(() => batch(args)))
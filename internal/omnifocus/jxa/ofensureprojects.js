@@ -0,0 +1,62 @@
+// Ensure projects exist within Omnifocus, creating them, and any folders
+// on the way to them, if they don't. Each path is either a project name or
+// a "Folder/Subfolder/Project" path from the top level, and is looked up
+// like projectAt does. A project name without a folder matches a project
+// in any folder, and is created at the top level if there's none. Projects
+// given by ID, as "id:" followed by the ID, can't be created, so must
+// exist. It's an error for a path to match more than one project or
+// folder.
+// Accepts a ProjectPaths as JSON in an OSA_ARGS env var.
+// Call it:
+//   set -gx OSA_ARGS '{"paths": ["Work/GitHub/GitHub Reviews", "GitHub Notifications"]}'
+//   cat common.js ofensureprojects.js | osascript -l JavaScript | jq .
+// Returns JSON saying whether each project was created:
+// {"ok": true, "result": [true, false]}
+
+/**
+ * @typedef {Object} ProjectPaths
+ * @property {string[]} paths
+ */
+
+function ensureProjects(
+    /** @type {ProjectPaths} */ q
+) {
+    // @ts-ignore
+    const ofApp = Application("OmniFocus")
+    const ofDoc = ofApp.defaultDocument
+
+    return q.paths.map((path) => {
+        // Only create a project that projectAt can't find, so we never
+        // create one that projectAt would then find ambiguous.
+        try {
+            projectAt(ofDoc, path)
+            return false
+        } catch (e) {
+            if (e.code !== "project-not-found" || path.startsWith("id:")) {
+                throw e
+            }
+        }
+
+        // projectAt has checked that each folder on the path that exists
+        // is the only one of its name.
+        const parts = path.split("/")
+        const name = parts.pop()
+        var container = ofDoc
+        parts.forEach((folderName) => {
+            const folders = container.folders.whose({ name: folderName })
+            if (folders.length > 0) {
+                container = folders[0]
+                return
+            }
+            const folder = ofApp.Folder({ name: folderName })
+            container.folders.push(folder)
+            container = folder
+        })
+        container.projects.push(ofApp.Project({ name: name }))
+        return true
+    })
+}
+
+ObjC.import('stdlib')
+var args = JSON.parse($.getenv('OSA_ARGS'))
+JSON.stringify(envelope(() => ensureProjects(args)))
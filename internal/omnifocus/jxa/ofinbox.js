@@ -1,6 +1,7 @@
 // A JS script to load up Omnifocus inbox tasks
+// Returns JSON with the tasks in an array: {"ok": true, "result": [...]}
 // Run it using:
-// 	osascript -l JavaScript ofinbox.js | jq .
+// 	cat common.js ofinbox.js | osascript -l JavaScript | jq .
 
 function inbox() {
 	var of = Application("OmniFocus")
@@ -14,4 +15,4 @@ function inbox() {
 }
 
 ObjC.import('stdlib')
-JSON.stringify(inbox())
+JSON.stringify(envelope(() => inbox()))
@@ -0,0 +1,108 @@
+// Return every incomplete task having a given tag that lives in one of a
+// list of projects, or anywhere at all if "anywhere" is true. Dropped tasks
+// are left out. If "completedSinceMS" is set, the tasks completed since
+// then are returned instead, with the time they were completed as
+// "completedMS". Rather than walking each project, we start from the tag's
+// tasks and read each property for all the tasks at once, which keeps the
+// number of Apple Events small.
+// Accepts a ManagedTaskQuery as JSON in an OSA_ARGS env var.
+// Call it:
+//   set -gx OSA_ARGS '{"tag": "github", "projectNames": ["GitHub Assigned", "GitHub Reviews", "GitHub Notifications"], "anywhere": false}'
+//   cat common.js ofmanagedtasks.js | osascript -l JavaScript | jq .
+// Returns JSON with the tasks in an array:
+// {"ok": true, "result": [
+//     {
+//       "id": "iAKv1Uo8XqW",
+//       "projectName": "GitHub Assigned",
+//       "name": "cloudant/techspec-documents#257 Document modernize search project progress",
+//       "note": "https://github.com/cloudant/techspec-documents/issues/257",
+//       "tags": ["github", "assigned"],
+//       "dueDateMS": 1640995199000,
+//       "deferDateMS": 0,
+//       "flagged": false,
+//       "createdMS": 1640908800000
+//     }, ...
+// ]}
+// or, on failure, {"ok": false, "error": {"code": "project-not-found", "message": "..."}}
+// Tasks outside the listed projects have an empty projectName.
+
+/**
+ * @typedef {Object} ManagedTaskQuery
+ * @property {string} tag
+ * @property {string[]} projectNames
+ * @property {boolean} [anywhere]
+ * @property {integer} [completedSinceMS]
+ */
+
+function managedTasks(
+    /** @type {ManagedTaskQuery} */ query
+) {
+    // @ts-ignore
+    const ofApp = Application("OmniFocus")
+    const ofDoc = ofApp.defaultDocument
+
+    // Map each project's ID to the name we were asked for it by, which may
+    // be a path.
+    const projectNames = {}
+    query.projectNames.forEach((name) => {
+        projectNames[projectAt(ofDoc, name).id()] = name
+    })
+
+    const tags = ofDoc.flattenedTags.whose({ name: query.tag })
+    if (tags.length === 0) {
+        // No task can have a tag that doesn't exist.
+        return []
+    }
+
+    const tasks = tags[0].tasks
+    const ids = tasks.id()
+    const names = tasks.name()
+    const notes = tasks.note()
+    const completed = tasks.completed()
+    const dropped = tasks.dropped()
+    const completionDates = query.completedSinceMS ? tasks.completionDate() : []
+    const tagNames = tasks.tags.name()
+    const dueDates = tasks.dueDate()
+    const deferDates = tasks.deferDate()
+    const flagged = tasks.flagged()
+    const created = tasks.creationDate()
+    const taskList = tasks()
+
+    const wanted = i => {
+        if (query.completedSinceMS) {
+            return completed[i] && completionDates[i] && completionDates[i].getTime() >= query.completedSinceMS
+        }
+        return !completed[i] && !dropped[i]
+    }
+
+    const r = []
+    for (var i = 0; i < ids.length; i++) {
+        if (!wanted(i)) {
+            continue
+        }
+        // Looking up the project is an Apple Event per task, so is only
+        // done for the tasks we want.
+        const project = taskList[i].containingProject()
+        const projectName = project ? projectNames[project.id()] : undefined
+        if (projectName === undefined && !query.anywhere) {
+            continue
+        }
+        r.push({
+            "id": ids[i],
+            "projectName": projectName || "",
+            "name": names[i],
+            "note": notes[i],
+            "tags": tagNames[i],
+            "dueDateMS": dueDates[i] ? dueDates[i].getTime() : 0,
+            "deferDateMS": deferDates[i] ? deferDates[i].getTime() : 0,
+            "flagged": flagged[i],
+            "createdMS": created[i].getTime(),
+            "completedMS": completionDates[i] ? completionDates[i].getTime() : 0,
+        })
+    }
+    return r
+}
+
+ObjC.import('stdlib')
+var args = JSON.parse($.getenv('OSA_ARGS'))
+JSON.stringify(envelope(() => managedTasks(args)))
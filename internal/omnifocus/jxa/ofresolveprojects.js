@@ -0,0 +1,45 @@
+// Resolve references to projects into project IDs, so that a run reads
+// and writes tasks in the same projects however they're named.
+// Accepts a ProjectRefs as JSON in an OSA_ARGS env var. Each ref is either
+// "id:" followed by a project ID, a project name or a
+// "Folder/Subfolder/Project" path.
+// Call it:
+//   set -gx OSA_ARGS '{"refs": ["Work/GitHub Reviews", "GitHub Notifications"]}'
+//   cat common.js ofresolveprojects.js | osascript -l JavaScript | jq .
+// Returns JSON with the project for each ref, in order:
+// {"ok": true, "result": [
+//     {"id": "jBo2Tle5dDz", "name": "GitHub Reviews"}, ...
+// ]}
+// or, on failure, an error with code "project-not-found" or
+// "project-ambiguous". If allowMissing is set, a project that can't be
+// found is null in the result rather than an error.
+
+/**
+ * @typedef {Object} ProjectRefs
+ * @property {string[]} refs
+ * @property {boolean} [allowMissing]
+ */
+
+function resolveProjects(
+    /** @type {ProjectRefs} */ q
+) {
+    // @ts-ignore
+    const ofApp = Application("OmniFocus")
+    const ofDoc = ofApp.defaultDocument
+
+    return q.refs.map((ref) => {
+        try {
+            const project = projectAt(ofDoc, ref)
+            return { "id": project.id(), "name": project.name() }
+        } catch (e) {
+            if (q.allowMissing && e.code === "project-not-found") {
+                return null
+            }
+            throw e
+        }
+    })
+}
+
+ObjC.import('stdlib')
+var args = JSON.parse($.getenv('OSA_ARGS'))
+JSON.stringify(envelope(() => resolveProjects(args)))
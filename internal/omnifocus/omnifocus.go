@@ -1,9 +1,12 @@
 package omnifocus
 
 import (
+	"cmp"
+	"crypto/sha256"
 	"embed"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,10 +18,37 @@ var (
 	jxa embed.FS
 )
 
+// Kind is the kind of GitHub item a task was created for. Each kind has
+// its own project and type tag.
+type Kind string
+
+const (
+	KindAssigned     Kind = "assigned"
+	KindReview       Kind = "review"
+	KindNotification Kind = "notification"
+)
+
+// Kinds lists every Kind, in priority order. When an item is of more than
+// one kind, its task is of the first kind in this list.
+var Kinds = []Kind{KindAssigned, KindReview, KindNotification}
+
 // Task represents a task existing in Omnifocus
 type Task struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID          string   `json:"id"`
+	ProjectName string   `json:"projectName,omitempty"`
+	Name        string   `json:"name"`
+	Note        string   `json:"note,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	DueDateMS   int64    `json:"dueDateMS,omitempty"`
+	DeferDateMS int64    `json:"deferDateMS,omitempty"`
+	Flagged     bool     `json:"flagged,omitempty"`
+	CreatedMS   int64    `json:"createdMS,omitempty"`
+	CompletedMS int64    `json:"completedMS,omitempty"`
+	Kind        Kind     `json:"kind,omitempty"`
+
+	// fingerprint is set by the Gateway when it reads the task, as what
+	// the fingerprint covers depends on the gateway's configuration.
+	fingerprint string
 }
 
 func (t Task) String() string {
@@ -36,140 +66,552 @@ func (t Task) Key() string {
 	return strings.SplitN(t.Name, " ", 2)[0] //nolint:gomnd
 }
 
-// TaskQuery defines a query to find Omnifocus tasks
-type TaskQuery struct {
-	ProjectName string   `json:"projectName"`
-	Tags        []string `json:"tags"`
+// URL returns the GitHub URL stored in the first line of the task's note.
+func (t Task) URL() string {
+	return strings.SplitN(t.Note, "\n", 2)[0] //nolint:gomnd
+}
+
+// Fingerprint meets the delta.Fingerprinted interface, allowing changes to
+// the GitHub item to be detected.
+func (t Task) Fingerprint() string {
+	return t.fingerprint
+}
+
+// Category meets the delta.Categorised interface, allowing an item that
+// has changed kind to be moved.
+func (t Task) Category() string {
+	return string(t.Kind)
+}
+
+// DesiredTask is the task the Gateway wants to exist in Omnifocus for a
+// GitHub item.
+type DesiredTask struct {
+	Item gh.GitHubItem    `json:"item"`
+	Kind Kind             `json:"kind"`
+	Task NewOmnifocusTask `json:"task"`
+
+	fingerprint     string
+	syncFingerprint string
+}
+
+func (d DesiredTask) String() string {
+	return d.Item.String()
+}
+
+// Key meets the Keyed interface used for creating delta operations in
+// github2omnifocus.
+func (d DesiredTask) Key() string {
+	return d.Item.Key()
+}
+
+// Fingerprint meets the delta.Fingerprinted interface, allowing changes to
+// the GitHub item to be detected.
+func (d DesiredTask) Fingerprint() string {
+	return d.fingerprint
+}
+
+// SyncFingerprint meets the state.Item interface, identifying the version
+// of the GitHub item across runs. Unlike Fingerprint, it covers where a
+// notification's HTML URL comes from rather than the URL itself, so it is
+// known before the URL has been resolved.
+func (d DesiredTask) SyncFingerprint() string {
+	return d.syncFingerprint
+}
+
+// Category meets the delta.Categorised interface, allowing an item that
+// has changed kind to be moved.
+func (d DesiredTask) Category() string {
+	return string(d.Kind)
+}
+
+// ManagedTaskQuery defines a query for the incomplete tasks having Tag in
+// any of the projects in ProjectNames. If Anywhere is true, tasks having
+// Tag elsewhere, including the inbox, are also returned, with an empty
+// ProjectName. If CompletedSinceMS is set, the tasks completed since then
+// are returned instead of incomplete tasks.
+type ManagedTaskQuery struct {
+	Tag              string   `json:"tag"`
+	ProjectNames     []string `json:"projectNames"`
+	Anywhere         bool     `json:"anywhere,omitempty"`
+	CompletedSinceMS int64    `json:"completedSinceMS,omitempty"`
 }
 
-// NewOmnifocusTask defines a request to create a new task
+// NewOmnifocusTask defines a request to create a new task. If Inbox is
+// true, the task is created in the inbox and assigned to the project, so it
+// moves there when the inbox is cleaned up.
 type NewOmnifocusTask struct {
 	ProjectName string   `json:"projectName"`
+	Inbox       bool     `json:"inbox,omitempty"`
 	Name        string   `json:"name"`
 	Tags        []string `json:"tags"`
 	Note        string   `json:"note"`
 	DueDateMS   int64    `json:"dueDateMS"`
+	DeferDateMS int64    `json:"deferDateMS,omitempty"`
+	Flagged     bool     `json:"flagged,omitempty"`
+}
+
+// TaskUpdate defines a request to update an existing task. The first line
+// of the task's note is replaced with URL, leaving any notes the user added
+// below it intact. A zero DueDateMS or DeferDateMS leaves the task's date
+// unchanged, as does a false Flagged for its flag, and an empty
+// ProjectName leaves the task where it is.
+type TaskUpdate struct {
+	ID          string   `json:"id"`
+	ProjectName string   `json:"projectName,omitempty"`
+	Name        string   `json:"name"`
+	URL         string   `json:"url"`
+	AddTags     []string `json:"addTags"`
+	RemoveTags  []string `json:"removeTags"`
+	DueDateMS   int64    `json:"dueDateMS"`
+	DeferDateMS int64    `json:"deferDateMS,omitempty"`
+	Flagged     bool     `json:"flagged,omitempty"`
+}
+
+// BatchOperation is a single write carried out by RunBatch. Exactly one
+// field should be set. Complete, Drop and Delete only require the task's
+// ID. Reopen marks a completed task incomplete before updating it.
+type BatchOperation struct {
+	Add      *NewOmnifocusTask `json:"add,omitempty"`
+	Complete *Task             `json:"complete,omitempty"`
+	Drop     *Task             `json:"drop,omitempty"`
+	Delete   *Task             `json:"delete,omitempty"`
+	Update   *TaskUpdate       `json:"update,omitempty"`
+	Reopen   *TaskUpdate       `json:"reopen,omitempty"`
 }
 
-// Tag represents an Omnifocus tag
-type Tag struct {
+// BatchResult is the outcome of a BatchOperation. Task is the task
+// created by an add operation.
+type BatchResult struct {
+	Task Task
+	Err  error
+}
+
+// Project represents an Omnifocus project
+type Project struct {
+	ID   string `json:"id"`
 	Name string `json:"name"`
 }
 
+// ProjectIDPrefix starts a reference to a project by ID rather than by
+// name or path, such as "id:jBo2Tle5dDz".
+const ProjectIDPrefix = "id:"
+
 type Gateway struct {
-	AppTag                  string
-	AssignedTag             string
-	AssignedProject         string
-	ReviewTag               string
-	ReviewProject           string
-	NotificationTag         string
-	NotificationsProject    string
-	SetNotificationsDueDate bool
-	DueDate                 time.Time
-}
-
-func (og *Gateway) GetIssues() ([]Task, error) {
-	tasks, err := TasksForQuery(TaskQuery{
-		ProjectName: og.AssignedProject,
-		Tags:        []string{og.AppTag, og.AssignedTag},
-	})
+	// Runner runs the JXA scripts that talk to Omnifocus. If nil, an
+	// OsascriptRunner using DefaultOsascriptPath is used.
+	Runner ScriptRunner
+
+	AppTag               string
+	AssignedTag          string
+	AssignedProject      string
+	ReviewTag            string
+	ReviewProject        string
+	NotificationTag      string
+	NotificationsProject string
+	// DueDates and DeferDates hold, for each kind of task, the due and
+	// defer dates given to new tasks. Kinds without a date get none.
+	DueDates   map[Kind]time.Time
+	DeferDates map[Kind]time.Time
+	// Flagged holds the kinds of task that are flagged when created.
+	Flagged map[Kind]bool
+	// Inbox holds the kinds of task that are created in the inbox, assigned
+	// to their project, rather than in the project itself. As the user may
+	// then file them anywhere, tasks of these kinds are found by their tags
+	// alone.
+	Inbox map[Kind]bool
+	// Anywhere holds the kinds of task that are found by their tags alone,
+	// wherever the user has filed them, rather than only within their
+	// project.
+	Anywhere map[Kind]bool
+	// ReopenWithin holds, for each kind of task, how long after a task is
+	// completed it is reopened, rather than a new task added, if its item
+	// needs a task again. Kinds without a duration are never reopened.
+	ReopenWithin map[Kind]time.Duration
+
+	// resolved maps each project as configured above to a reference to
+	// it by ID, once ResolveProjects has been called.
+	resolved map[string]string
+	// missing holds the projects as configured above that ResolveProjects
+	// found don't exist yet.
+	missing []string
+}
+
+// runner returns the ScriptRunner to use to talk to Omnifocus.
+func (og *Gateway) runner() ScriptRunner {
+	if og.Runner == nil {
+		return OsascriptRunner{}
+	}
+	return og.Runner
+}
+
+// GetTasks retrieves the tasks of every kind that github2omnifocus manages,
+// oldest first.
+func (og *Gateway) GetTasks() ([]Task, error) {
+	r, err := og.queryTasks(0)
 	if err != nil {
 		return nil, err
 	}
-	return tasks, nil
+	SortOldestFirst(r)
+	return r, nil
 }
 
-func (og *Gateway) GetPRs() ([]Task, error) {
-	tasks, err := TasksForQuery(TaskQuery{
-		ProjectName: og.ReviewProject,
-		Tags:        []string{og.AppTag, og.ReviewTag},
-	})
+// GetCompletedTasks retrieves the tasks that were completed recently enough
+// to be reopened, according to ReopenWithin, most recently completed first.
+func (og *Gateway) GetCompletedTasks(now time.Time) ([]Task, error) {
+	longest := time.Duration(0)
+	for _, k := range Kinds {
+		longest = max(longest, og.ReopenWithin[k])
+	}
+	if longest == 0 {
+		return []Task{}, nil
+	}
+
+	tasks, err := og.queryTasks(now.Add(-longest).UnixMilli())
 	if err != nil {
 		return nil, err
 	}
+	tasks = slices.DeleteFunc(tasks, func(t Task) bool {
+		return t.CompletedMS < now.Add(-og.ReopenWithin[t.Kind]).UnixMilli()
+	})
+	slices.SortStableFunc(tasks, func(a, b Task) int {
+		return cmp.Compare(b.CompletedMS, a.CompletedMS)
+	})
 	return tasks, nil
 }
 
-func (og *Gateway) GetNotifications() ([]Task, error) {
-	tasks, err := TasksForQuery(TaskQuery{
-		ProjectName: og.NotificationsProject,
-		Tags:        []string{og.AppTag, og.NotificationTag},
-	})
+// queryTasks retrieves the tasks of every kind that github2omnifocus
+// manages, and fingerprints them. If completedSinceMS is zero, incomplete
+// tasks are retrieved; otherwise, the tasks completed since then are.
+func (og *Gateway) queryTasks(completedSinceMS int64) ([]Task, error) {
+	// A project that doesn't exist yet has no tasks.
+	q := ManagedTaskQuery{
+		Tag: og.AppTag,
+		ProjectNames: slices.DeleteFunc(og.projects(), func(p string) bool {
+			return slices.Contains(og.missing, p)
+		}),
+		CompletedSinceMS: completedSinceMS,
+	}
+	for _, k := range Kinds {
+		q.Anywhere = q.Anywhere || og.anywhere(k)
+	}
+	tasks, err := ManagedTasks(og.runner(), q)
 	if err != nil {
 		return nil, err
 	}
-	return tasks, nil
+
+	r := []Task{}
+	for _, t := range tasks {
+		k, ok := og.kind(t)
+		if !ok {
+			continue
+		}
+		t.Kind = k
+		t.Tags = og.managedTags(t.Tags)
+		t.fingerprint = fingerprint(t.Name, t.URL(), t.Tags)
+		r = append(r, t)
+	}
+	return r, nil
 }
 
-func (og *Gateway) AddIssue(t gh.GitHubItem) error {
-	log.Printf("AddIssue: %s", t)
-	_, err := AddNewOmnifocusTask(NewOmnifocusTask{
-		ProjectName: og.AssignedProject,
-		Name:        t.Key() + " " + t.Title,
-		Tags:        []string{og.AppTag, og.AssignedTag},
-		Note:        t.HTMLURL,
-	})
+// EnsureProjects creates any of the projects used for tasks that don't
+// exist in Omnifocus, along with any folders in their paths.
+func (og *Gateway) EnsureProjects() error {
+	paths := og.projects()
+	created, err := EnsureProjectsExist(og.runner(), paths)
 	if err != nil {
-		return fmt.Errorf("error adding task: %v", err)
+		return fmt.Errorf("error creating projects: %w", err)
+	}
+	for i, c := range created {
+		if c {
+			log.Printf("EnsureProjects: created project %s", paths[i])
+		}
 	}
 	return nil
 }
 
-func (og *Gateway) AddPR(t gh.GitHubItem) error {
-	log.Printf("AddPR: %s", t)
-	_, err := AddNewOmnifocusTask(NewOmnifocusTask{
-		ProjectName: og.ReviewProject,
-		Name:        t.Key() + " " + t.Title,
-		Tags:        []string{og.AppTag, og.ReviewTag},
-		Note:        t.HTMLURL,
-	})
+// ResolveProjects looks up the projects used for tasks, so that the rest
+// of the run refers to them by ID. This ensures we read and write tasks in
+// the same project, and fails if a project's name or path matches more
+// than one project.
+//
+// If allowMissing is true, projects that don't exist aren't an error.
+// They're returned, and the rest of the run treats them as empty and
+// refers to them as configured, so that a plan can be made before they
+// are created.
+func (og *Gateway) ResolveProjects(allowMissing bool) ([]string, error) {
+	og.resolved, og.missing = nil, nil
+	refs := og.projects()
+	projects, err := ResolveProjects(og.runner(), refs, allowMissing)
 	if err != nil {
-		return fmt.Errorf("error adding task: %v", err)
+		return nil, fmt.Errorf("error resolving projects: %w", err)
 	}
-	return nil
+	og.resolved = map[string]string{}
+	missing := []string{}
+	for i, p := range projects {
+		if p.ID == "" {
+			log.Printf("ResolveProjects: %s doesn't exist yet", refs[i])
+			missing = append(missing, refs[i])
+			continue
+		}
+		log.Printf("ResolveProjects: %s is project %s", refs[i], p.ID)
+		og.resolved[refs[i]] = ProjectIDPrefix + p.ID
+	}
+	og.missing = missing
+	return missing, nil
 }
 
-func (og *Gateway) AddNotification(t gh.GitHubItem) error {
-	log.Printf("AddNotification: %s", t)
-	newT := NewOmnifocusTask{
-		ProjectName: og.NotificationsProject,
-		Name:        t.Key() + " " + t.Title,
-		Tags:        []string{og.AppTag, og.NotificationTag},
-		Note:        t.HTMLURL,
+// projects returns the projects used for tasks of every kind, without
+// repeats.
+func (og *Gateway) projects() []string {
+	r := []string{}
+	for _, k := range Kinds {
+		if !slices.Contains(r, og.project(k)) {
+			r = append(r, og.project(k))
+		}
 	}
-	if og.SetNotificationsDueDate {
-		newT.DueDateMS = og.DueDate.UnixMilli()
+	return r
+}
+
+// kind returns the kind of t, which is the first kind whose project t is
+// in and whose type tag t has.
+func (og *Gateway) kind(t Task) (Kind, bool) {
+	for _, k := range Kinds {
+		if t.ProjectName == og.project(k) && slices.Contains(t.Tags, og.typeTag(k)) {
+			return k, true
+		}
 	}
-	_, err := AddNewOmnifocusTask(newT)
-	if err != nil {
-		return fmt.Errorf("error adding task: %v", err)
+	// Otherwise, a task found elsewhere is the first kind that may be
+	// anywhere whose type tag it has.
+	for _, k := range Kinds {
+		if og.anywhere(k) && slices.Contains(t.Tags, og.typeTag(k)) {
+			return k, true
+		}
 	}
-	return nil
+	return "", false
 }
 
-func (og *Gateway) CompleteIssue(t Task) error {
-	log.Printf("CompleteIssue: %s", t)
-	err := MarkOmnifocusTaskComplete(t)
-	if err != nil {
-		return fmt.Errorf("error completing task: %v", err)
+// anywhere returns true if tasks of kind k may be outside k's project.
+func (og *Gateway) anywhere(k Kind) bool {
+	return og.Inbox[k] || og.Anywhere[k]
+}
+
+// SortOldestFirst sorts tasks by the date they were created, oldest first.
+// When tasks are passed to delta.Delta in this order, the oldest task for
+// a key is kept and any later ones are reported as duplicates.
+func SortOldestFirst(tasks []Task) {
+	slices.SortStableFunc(tasks, func(a, b Task) int {
+		return cmp.Compare(a.CreatedMS, b.CreatedMS)
+	})
+}
+
+// DesiredIssues returns the tasks that should exist for the assigned
+// issues in items.
+func (og *Gateway) DesiredIssues(items []gh.GitHubItem) []DesiredTask {
+	return og.desiredTasks(KindAssigned, items)
+}
+
+// DesiredPRs returns the tasks that should exist for the PRs awaiting
+// review in items.
+func (og *Gateway) DesiredPRs(items []gh.GitHubItem) []DesiredTask {
+	return og.desiredTasks(KindReview, items)
+}
+
+// DesiredNotifications returns the tasks that should exist for the
+// notifications in items.
+func (og *Gateway) DesiredNotifications(items []gh.GitHubItem) []DesiredTask {
+	return og.desiredTasks(KindNotification, items)
+}
+
+// Desired returns the tasks that should exist for the GitHub items of
+// every kind. An item can appear in more than one of issues, prs and
+// notifications, for example an assigned issue with a new comment. As we
+// only want one task per item, the first kind in Kinds that contains an
+// item's key wins.
+func (og *Gateway) Desired(issues, prs, notifications []gh.GitHubItem) []DesiredTask {
+	r := []DesiredTask{}
+	seen := map[string]bool{}
+	all := [][]DesiredTask{
+		og.DesiredIssues(issues),
+		og.DesiredPRs(prs),
+		og.DesiredNotifications(notifications),
 	}
-	return nil
+	for _, tasks := range all {
+		for _, d := range tasks {
+			if seen[d.Key()] {
+				continue
+			}
+			seen[d.Key()] = true
+			r = append(r, d)
+		}
+	}
+	return r
 }
 
-func (og *Gateway) CompletePR(t Task) error {
-	log.Printf("CompletePR: %s", t)
-	err := MarkOmnifocusTaskComplete(t)
-	if err != nil {
-		return fmt.Errorf("error completing task: %v", err)
+// HoldYoung returns the tasks in desired, leaving out those which don't
+// already have a task in current and whose GitHub item was updated more
+// recently than the minimum age for its kind, as returned by minAge. This
+// gives the user a chance to deal with an item, for example by reading a
+// notification, before a task is created for it. As the item is still
+// desired, its task is created on a later run if it's still there.
+//
+// Items in synced or tombstones, the state's synced and tombstoned
+// fingerprints, are passed through while their fingerprint is unchanged,
+// however recently they were updated. This lets state.Tombstone see that
+// the user completed the task of a synced item, and keep tombstoned items
+// tombstoned.
+func HoldYoung(desired []DesiredTask, current []Task, synced, tombstones map[string]string, minAge func(Kind) time.Duration, now time.Time) []DesiredTask {
+	hasTask := map[string]bool{}
+	for _, t := range current {
+		hasTask[t.Key()] = true
 	}
-	return nil
+	r := []DesiredTask{}
+	for _, d := range desired {
+		age := now.Sub(d.Item.UpdatedAt)
+		known := false
+		for _, fps := range []map[string]string{synced, tombstones} {
+			fp, ok := fps[d.Key()]
+			known = known || (ok && fp == d.SyncFingerprint())
+		}
+		if minimum := minAge(d.Kind); !hasTask[d.Key()] && !known && age < minimum {
+			log.Printf("Holding: %s was updated %s ago; waiting until it's %s old", d, age.Round(time.Second), minimum)
+			continue
+		}
+		r = append(r, d)
+	}
+	return r
 }
 
-func (og *Gateway) CompleteNotification(t Task) error {
-	log.Printf("CompleteNotification: %s", t)
-	err := MarkOmnifocusTaskComplete(t)
-	if err != nil {
-		return fmt.Errorf("error completing task: %v", err)
+// WithItem returns d with its GitHub item replaced by item, such as once
+// the item's HTML URL has been resolved.
+func (og *Gateway) WithItem(d DesiredTask, item gh.GitHubItem) DesiredTask {
+	return og.desiredTasks(d.Kind, []gh.GitHubItem{item})[0]
+}
+
+func (og *Gateway) desiredTasks(k Kind, items []gh.GitHubItem) []DesiredTask {
+	r := []DesiredTask{}
+	for _, item := range items {
+		t := og.newTask(k, item)
+		source := item.HTMLURLSource
+		if source == "" {
+			source = t.Note
+		}
+		r = append(r, DesiredTask{
+			Item:            item,
+			Kind:            k,
+			Task:            t,
+			fingerprint:     fingerprint(t.Name, t.Note, t.Tags),
+			syncFingerprint: fingerprint(t.Name, source, t.Tags),
+		})
 	}
-	return nil
+	return r
+}
+
+// newTask returns the request to create the task of kind k for item.
+func (og *Gateway) newTask(k Kind, item gh.GitHubItem) NewOmnifocusTask {
+	t := NewOmnifocusTask{
+		ProjectName: og.project(k),
+		Name:        item.Key() + " " + item.Title,
+		Tags:        []string{og.AppTag, og.typeTag(k)},
+		Note:        item.HTMLURL,
+		Inbox:       og.Inbox[k],
+	}
+	if og.hasDueDate(k) {
+		t.DueDateMS = og.DueDates[k].UnixMilli()
+	}
+	if d := og.DeferDates[k]; !d.IsZero() {
+		t.DeferDateMS = d.UnixMilli()
+	}
+	t.Flagged = og.Flagged[k]
+	return t
+}
+
+// project returns the project that tasks of kind k live in, by ID if
+// ResolveProjects has been called.
+func (og *Gateway) project(k Kind) string {
+	p := ""
+	switch k {
+	case KindAssigned:
+		p = og.AssignedProject
+	case KindReview:
+		p = og.ReviewProject
+	case KindNotification:
+		p = og.NotificationsProject
+	}
+	if r, ok := og.resolved[p]; ok {
+		return r
+	}
+	return p
+}
+
+// typeTag returns the tag that identifies tasks of kind k.
+func (og *Gateway) typeTag(k Kind) string {
+	switch k {
+	case KindAssigned:
+		return og.AssignedTag
+	case KindReview:
+		return og.ReviewTag
+	case KindNotification:
+		return og.NotificationTag
+	}
+	return ""
+}
+
+// hasDueDate returns true if tasks of kind k are given a due date.
+func (og *Gateway) hasDueDate(k Kind) bool {
+	return !og.DueDates[k].IsZero()
+}
+
+// managedTags returns the tags in tags that github2omnifocus manages,
+// ignoring any that the user has added.
+func (og *Gateway) managedTags(tags []string) []string {
+	r := []string{}
+	for _, t := range tags {
+		switch t {
+		case og.AppTag, og.AssignedTag, og.ReviewTag, og.NotificationTag:
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+// fingerprint summarises the parts of a task that github2omnifocus keeps in
+// line with GitHub. Dates are only set when a task is created, moved or
+// reopened, so that the user can change or clear them, and aren't
+// included.
+func fingerprint(name, url string, tags []string) string {
+	sorted := slices.Sorted(slices.Values(tags))
+	s := fmt.Sprintf("%s\n%s\n%s", name, url, strings.Join(sorted, ","))
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
+
+// moveUpdate returns the update that moves t to the project for d's kind
+// and brings it into line with d. If tasks of d's kind may be anywhere and
+// the user has filed t outside our projects, it's left where it is.
+func (og *Gateway) moveUpdate(t Task, d DesiredTask) TaskUpdate {
+	u := og.taskUpdate(t, d)
+	if !og.anywhere(d.Kind) || t.ProjectName != "" {
+		u.ProjectName = d.Task.ProjectName
+	}
+	// A task without a due date is given its new kind's.
+	if t.DueDateMS == 0 {
+		u.DueDateMS = d.Task.DueDateMS
+	}
+	return u
+}
+
+// taskUpdate returns the update that brings t into line with d.
+func (og *Gateway) taskUpdate(t Task, d DesiredTask) TaskUpdate {
+	u := TaskUpdate{
+		ID:         t.ID,
+		Name:       d.Task.Name,
+		URL:        d.Task.Note,
+		AddTags:    d.Task.Tags,
+		RemoveTags: []string{},
+	}
+	for _, tag := range t.Tags {
+		if !slices.Contains(d.Task.Tags, tag) {
+			u.RemoveTags = append(u.RemoveTags, tag)
+		}
+	}
+	return u
 }
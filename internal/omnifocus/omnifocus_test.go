@@ -1,6 +1,12 @@
 package omnifocus
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/gh"
+	"github.com/mikerhodes/github-to-omnifocus/internal/state"
+)
 
 func TestTaskKey(t *testing.T) {
 	task := Task{
@@ -12,3 +18,180 @@ func TestTaskKey(t *testing.T) {
 		t.Fatalf("Didn't get expected key, got: %s", k)
 	}
 }
+
+func TestTaskURL(t *testing.T) {
+	task := Task{
+		Note: "https://github.com/mikerhodes/github-to-omnifocus/issues/3\nmy own notes",
+	}
+	if u := task.URL(); u != "https://github.com/mikerhodes/github-to-omnifocus/issues/3" {
+		t.Fatalf("Didn't get expected URL, got: %s", u)
+	}
+}
+
+func TestFingerprintMatchesDesired(t *testing.T) {
+	og := Gateway{AppTag: "github", AssignedTag: "assigned", ReviewTag: "review", NotificationTag: "notification"}
+	item := gh.GitHubItem{
+		Title:   "foo bar",
+		HTMLURL: "https://github.com/mikerhodes/github-to-omnifocus/issues/3",
+		K:       "mikerhodes/github-to-omnifocus#3",
+	}
+	d := og.DesiredIssues([]gh.GitHubItem{item})[0]
+
+	task := Task{
+		Name: "mikerhodes/github-to-omnifocus#3 foo bar",
+		Note: "https://github.com/mikerhodes/github-to-omnifocus/issues/3\nmy own notes",
+		Tags: og.managedTags([]string{"assigned", "someday", "github"}),
+	}
+	if fingerprint(task.Name, task.URL(), task.Tags) != d.Fingerprint() {
+		t.Fatal("Expected unchanged task to match desired fingerprint")
+	}
+
+	task.Name = "mikerhodes/github-to-omnifocus#3 old title"
+	if fingerprint(task.Name, task.URL(), task.Tags) == d.Fingerprint() {
+		t.Fatal("Expected renamed task not to match desired fingerprint")
+	}
+}
+
+func TestSyncFingerprintIgnoresResolvedURL(t *testing.T) {
+	og := Gateway{AppTag: "github", AssignedTag: "assigned", ReviewTag: "review", NotificationTag: "notification"}
+	item := gh.GitHubItem{
+		Title:         "foo bar",
+		HTMLURLSource: "https://api.github.com/repos/mikerhodes/github-to-omnifocus/issues/comments/1",
+		K:             "mikerhodes/github-to-omnifocus#3",
+	}
+	d := og.DesiredNotifications([]gh.GitHubItem{item})[0]
+
+	item.HTMLURL = "https://github.com/mikerhodes/github-to-omnifocus/issues/3#issuecomment-1"
+	resolved := og.WithItem(d, item)
+	if resolved.SyncFingerprint() != d.SyncFingerprint() {
+		t.Fatal("Expected resolving the URL not to change the sync fingerprint")
+	}
+	if resolved.Fingerprint() == d.Fingerprint() {
+		t.Fatal("Expected resolving the URL to change the fingerprint")
+	}
+
+	item.HTMLURLSource = "https://api.github.com/repos/mikerhodes/github-to-omnifocus/issues/comments/2"
+	if og.WithItem(d, item).SyncFingerprint() == d.SyncFingerprint() {
+		t.Fatal("Expected a new comment to change the sync fingerprint")
+	}
+}
+
+func TestDesiredPrefersAssigned(t *testing.T) {
+	og := Gateway{AppTag: "github", AssignedTag: "assigned", ReviewTag: "review", NotificationTag: "notification"}
+	issue := gh.GitHubItem{Title: "foo", K: "mikerhodes/github-to-omnifocus#3"}
+	other := gh.GitHubItem{Title: "bar", K: "mikerhodes/github-to-omnifocus#4"}
+
+	desired := og.Desired(
+		[]gh.GitHubItem{issue},
+		[]gh.GitHubItem{issue},
+		[]gh.GitHubItem{issue, other},
+	)
+	if len(desired) != 2 {
+		t.Fatalf("Expected 2 desired tasks, got %d", len(desired))
+	}
+	if desired[0].Key() != issue.Key() || desired[0].Kind != KindAssigned {
+		t.Fatalf("Expected assigned task for issue, got %+v", desired[0])
+	}
+	if desired[1].Key() != other.Key() || desired[1].Kind != KindNotification {
+		t.Fatalf("Expected notification task for other, got %+v", desired[1])
+	}
+}
+
+func TestSortOldestFirst(t *testing.T) {
+	tasks := []Task{
+		{ID: "c", CreatedMS: 300},
+		{ID: "a", CreatedMS: 100},
+		{ID: "b", CreatedMS: 200},
+	}
+	SortOldestFirst(tasks)
+	if tasks[0].ID != "a" || tasks[1].ID != "b" || tasks[2].ID != "c" {
+		t.Fatalf("Tasks not sorted oldest first: %v", tasks)
+	}
+}
+
+func TestHoldYoung(t *testing.T) {
+	now := time.Now()
+	young := DesiredTask{Kind: KindNotification, Item: gh.GitHubItem{K: "a/b#1", UpdatedAt: now.Add(-time.Minute)}}
+	old := DesiredTask{Kind: KindNotification, Item: gh.GitHubItem{K: "a/b#2", UpdatedAt: now.Add(-time.Hour)}}
+	existing := DesiredTask{Kind: KindNotification, Item: gh.GitHubItem{K: "a/b#3", UpdatedAt: now}}
+	issue := DesiredTask{Kind: KindAssigned, Item: gh.GitHubItem{K: "a/b#4", UpdatedAt: now}}
+
+	minAge := func(k Kind) time.Duration {
+		if k == KindNotification {
+			return 5 * time.Minute
+		}
+		return 0
+	}
+	current := []Task{{Name: "a/b#3 existing"}}
+
+	r := HoldYoung([]DesiredTask{young, old, existing, issue}, current, map[string]string{}, map[string]string{}, minAge, now)
+	if len(r) != 3 {
+		t.Fatalf("Expected 3 tasks, got %v", r)
+	}
+	for _, d := range r {
+		if d.Key() == young.Key() {
+			t.Fatal("Expected young notification to be held")
+		}
+	}
+}
+
+func TestHoldYoungPassesTombstones(t *testing.T) {
+	now := time.Now()
+	young := DesiredTask{Kind: KindNotification, Item: gh.GitHubItem{K: "a/b#1", UpdatedAt: now}, syncFingerprint: "1"}
+	minAge := func(Kind) time.Duration { return 5 * time.Minute }
+
+	r := HoldYoung([]DesiredTask{young}, []Task{}, map[string]string{}, map[string]string{"a/b#1": "1"}, minAge, now)
+	if len(r) != 1 {
+		t.Fatalf("Expected tombstoned item to be passed through, got %v", r)
+	}
+
+	// Once it has changed, it's held like any other young item.
+	r = HoldYoung([]DesiredTask{young}, []Task{}, map[string]string{}, map[string]string{"a/b#1": "0"}, minAge, now)
+	if len(r) != 0 {
+		t.Fatalf("Expected changed item to be held, got %v", r)
+	}
+}
+
+func TestHoldYoungKeepsCompletedTaskCompleted(t *testing.T) {
+	og := Gateway{AppTag: "github", AssignedTag: "assigned", ReviewTag: "review", NotificationTag: "notification"}
+	minAge := func(Kind) time.Duration { return 5 * time.Minute }
+	now := time.Now()
+	item := gh.GitHubItem{
+		Title:     "foo",
+		HTMLURL:   "https://github.com/a/b/issues/1",
+		K:         "a/b#1",
+		UpdatedAt: now.Add(-time.Hour),
+	}
+	task := Task{Name: "a/b#1 foo"}
+
+	// Run 1: the item is synced to its task.
+	desired := og.DesiredNotifications([]gh.GitHubItem{item})
+	s := state.New()
+	desired = HoldYoung(desired, []Task{task}, s.Synced, s.Tombstones, minAge, now)
+	_, s = state.Tombstone(s, desired, []Task{task}, state.Grace{}, now)
+	if _, ok := s.Synced[item.Key()]; !ok {
+		t.Fatalf("Expected item to be synced, got %+v", s)
+	}
+
+	// Run 2: the user completes the task, and the item is then updated on
+	// GitHub without changing, such as by a reaction.
+	item.UpdatedAt = now
+	desired = og.DesiredNotifications([]gh.GitHubItem{item})
+	desired = HoldYoung(desired, []Task{}, s.Synced, s.Tombstones, minAge, now)
+	desired, s = state.Tombstone(s, desired, []Task{}, state.Grace{}, now)
+	if len(desired) != 0 {
+		t.Fatalf("Expected no tasks to be wanted, got %v", desired)
+	}
+	if _, ok := s.Tombstones[item.Key()]; !ok {
+		t.Fatalf("Expected item to be tombstoned, got %+v", s)
+	}
+
+	// Run 3: once it's old enough, the task still isn't re-created.
+	now = now.Add(time.Hour)
+	desired = og.DesiredNotifications([]gh.GitHubItem{item})
+	desired = HoldYoung(desired, []Task{}, s.Synced, s.Tombstones, minAge, now)
+	desired, _ = state.Tombstone(s, desired, []Task{}, state.Grace{}, now)
+	if len(desired) != 0 {
+		t.Fatalf("Expected completed task not to be re-created, got %v", desired)
+	}
+}
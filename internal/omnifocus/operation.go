@@ -0,0 +1,205 @@
+package omnifocus
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/delta"
+)
+
+// Disposition says what is done to the task of a remove operation.
+type Disposition string
+
+const (
+	DispositionComplete Disposition = "complete"
+	DispositionDrop     Disposition = "drop"
+	DispositionDelete   Disposition = "delete"
+)
+
+// Operation is a single change to make to Omnifocus. Task is the existing
+// task for remove, update, move, duplicate and reopen operations, and
+// Desired is the task we want to exist for add, update, move and reopen
+// operations. Disposition says what happens to the task of a remove
+// operation; if empty, the task is completed.
+type Operation struct {
+	Type        delta.OperationType `json:"type"`
+	Task        *Task               `json:"task,omitempty"`
+	Desired     *DesiredTask        `json:"desired,omitempty"`
+	Disposition Disposition         `json:"disposition,omitempty"`
+}
+
+func (op Operation) String() string {
+	t := op.Type.String()
+	if op.Disposition != "" && op.Disposition != DispositionComplete {
+		t = fmt.Sprintf("%s (%s)", op.Type, op.Disposition)
+	}
+	switch {
+	case op.Task != nil && op.Desired != nil:
+		return fmt.Sprintf("%s %s -> %s", t, op.Task, op.Desired)
+	case op.Task != nil:
+		return fmt.Sprintf("%s %s", t, op.Task)
+	case op.Desired != nil:
+		return fmt.Sprintf("%s %s", t, op.Desired)
+	}
+	return t
+}
+
+// Check returns an error if op is missing the task or desired task its
+// type needs, as it may be when read from a plan file edited by hand.
+func (op Operation) Check() error {
+	if op.Type != delta.Add && op.Task == nil {
+		return fmt.Errorf("%s operation has no task", op.Type)
+	}
+	if op.Type != delta.Remove && op.Type != delta.Duplicate && op.Desired == nil {
+		return fmt.Errorf("%s operation has no desired task", op.Type)
+	}
+	return nil
+}
+
+// Operations converts the delta r into the operations that carry it out.
+func Operations(r delta.Result[DesiredTask, Task]) []Operation {
+	ops := []Operation{}
+	for _, d := range r.Add {
+		ops = append(ops, Operation{Type: delta.Add, Desired: &d})
+	}
+	for _, t := range r.Remove {
+		ops = append(ops, Operation{Type: delta.Remove, Task: &t})
+	}
+	for _, m := range r.Update {
+		ops = append(ops, Operation{Type: delta.Update, Task: &m.Current, Desired: &m.Desired})
+	}
+	for _, m := range r.Move {
+		ops = append(ops, Operation{Type: delta.Move, Task: &m.Current, Desired: &m.Desired})
+	}
+	for _, t := range r.Duplicate {
+		ops = append(ops, Operation{Type: delta.Duplicate, Task: &t})
+	}
+	return ops
+}
+
+// ReopenCompleted replaces each add operation in ops with an operation to
+// reopen a task in completed for the same item, where there is one. If more
+// than one task in completed is for the same item, the first is reopened.
+func ReopenCompleted(ops []Operation, completed []Task) []Operation {
+	byKey := map[string]Task{}
+	for _, t := range slices.Backward(completed) {
+		byKey[t.Key()] = t
+	}
+	r := []Operation{}
+	for _, op := range ops {
+		if op.Type == delta.Add && op.Desired != nil {
+			if t, ok := byKey[op.Desired.Key()]; ok {
+				delete(byKey, t.Key())
+				op = Operation{Type: delta.Reopen, Task: &t, Desired: op.Desired}
+			}
+		}
+		r = append(r, op)
+	}
+	return r
+}
+
+// OperationResult is the outcome of an operation carried out by ApplyAll.
+// TaskID is the ID of the task created by an add operation.
+type OperationResult struct {
+	Operation Operation
+	TaskID    string
+	Err       error
+}
+
+// batchSize is the most operations sent to Omnifocus in one script run,
+// keeping the arguments passed to osascript to a reasonable size.
+const batchSize = 100
+
+// Apply carries out op.
+func (og *Gateway) Apply(op Operation) error {
+	results, err := og.ApplyAll([]Operation{op})
+	if err != nil {
+		return err
+	}
+	return results[0].Err
+}
+
+// ApplyAll carries out ops, returning the result of each in the same
+// order. Rather than running a script per operation, the operations are
+// sent to Omnifocus in batches. An operation failing doesn't stop the rest
+// being carried out; an error is only returned if Omnifocus can't be
+// reached at all, in which case some operations may have been carried out.
+func (og *Gateway) ApplyAll(ops []Operation) ([]OperationResult, error) {
+	results := make([]OperationResult, len(ops))
+	batch := []BatchOperation{}
+	// indexes holds the index in ops of each operation in batch.
+	indexes := []int{}
+	for i, op := range ops {
+		results[i].Operation = op
+		bop, err := og.batchOperation(op)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		log.Printf("Apply: %s", op)
+		batch = append(batch, bop)
+		indexes = append(indexes, i)
+	}
+
+	for start := 0; start < len(batch); start += batchSize {
+		end := min(start+batchSize, len(batch))
+		brs, err := RunBatch(og.runner(), batch[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("error applying operations: %w", err)
+		}
+		for j, br := range brs {
+			r := &results[indexes[start+j]]
+			r.TaskID = br.Task.ID
+			if br.Err != nil {
+				r.Err = fmt.Errorf("error applying %s: %w", r.Operation.Type, br.Err)
+			}
+		}
+	}
+	return results, nil
+}
+
+// batchOperation returns the write to Omnifocus that carries out op.
+func (og *Gateway) batchOperation(op Operation) (BatchOperation, error) {
+	if err := op.Check(); err != nil {
+		return BatchOperation{}, err
+	}
+	switch op.Type {
+	case delta.Add:
+		if !slices.Contains(Kinds, op.Desired.Kind) {
+			return BatchOperation{}, fmt.Errorf("unknown kind of task: %s", op.Desired)
+		}
+		// The task is created as it was planned, even if the config or
+		// the date has changed since.
+		t := op.Desired.Task
+		return BatchOperation{Add: &t}, nil
+	case delta.Remove:
+		t := &Task{ID: op.Task.ID}
+		switch op.Disposition {
+		case "", DispositionComplete:
+			return BatchOperation{Complete: t}, nil
+		case DispositionDrop:
+			return BatchOperation{Drop: t}, nil
+		case DispositionDelete:
+			return BatchOperation{Delete: t}, nil
+		}
+		return BatchOperation{}, fmt.Errorf("unknown disposition: %s", op.Disposition)
+	case delta.Duplicate:
+		return BatchOperation{Complete: &Task{ID: op.Task.ID}}, nil
+	case delta.Update:
+		u := og.taskUpdate(*op.Task, *op.Desired)
+		return BatchOperation{Update: &u}, nil
+	case delta.Move:
+		u := og.moveUpdate(*op.Task, *op.Desired)
+		return BatchOperation{Update: &u}, nil
+	case delta.Reopen:
+		u := og.taskUpdate(*op.Task, *op.Desired)
+		u.ProjectName = op.Desired.Task.ProjectName
+		// The task's dates are likely long gone, so it's given the dates
+		// and flag planned for a new task.
+		t := op.Desired.Task
+		u.DueDateMS, u.DeferDateMS, u.Flagged = t.DueDateMS, t.DeferDateMS, t.Flagged
+		return BatchOperation{Reopen: &u}, nil
+	}
+	return BatchOperation{}, fmt.Errorf("unknown operation type: %s", op.Type)
+}
@@ -0,0 +1,104 @@
+// Package plan stores the operations github2omnifocus intends to make to
+// Omnifocus in a file, so they can be reviewed before they are applied.
+//
+// A plan records the fingerprints of the Omnifocus tasks it was computed
+// against. Before applying a plan we check that Omnifocus still holds the
+// same tasks; if it doesn't, the operations may no longer make sense and a
+// new plan must be made.
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/omnifocus"
+	"github.com/mikerhodes/github-to-omnifocus/internal/state"
+)
+
+// Version is the version of the plan file format written by this package.
+const Version = 1
+
+// Plan is a set of operations to apply to Omnifocus, and the state of
+// Omnifocus they were computed against.
+type Plan struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Base maps the ID of each task that existed when the plan was made
+	// to its fingerprint.
+	Base       map[string]string     `json:"base"`
+	Operations []omnifocus.Operation `json:"operations"`
+	// MissingProjects lists the projects that didn't exist when the plan
+	// was made, which are created when it's applied.
+	MissingProjects []string `json:"missingProjects,omitempty"`
+	// State is saved once the operations have been carried out.
+	State state.State `json:"state"`
+}
+
+// New returns a plan to carry out ops, which were computed against the
+// tasks in current. next is the state to save after ops are carried out.
+func New(ops []omnifocus.Operation, current []omnifocus.Task, next state.State) Plan {
+	return Plan{
+		Version:    Version,
+		Created:    time.Now(),
+		Base:       base(current),
+		Operations: ops,
+		State:      next,
+	}
+}
+
+// Check returns an error if current isn't the state that p was computed
+// against.
+func (p Plan) Check(current []omnifocus.Task) error {
+	if !maps.Equal(p.Base, base(current)) {
+		return fmt.Errorf("omnifocus tasks have changed since the plan was created at %s; create a new plan", p.Created.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Load reads a plan from path, failing if any of its operations is
+// malformed.
+func Load(path string) (Plan, error) {
+	bytes, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return Plan{}, fmt.Errorf("error reading plan from %s: %v", path, err)
+	}
+	p := Plan{}
+	err = json.Unmarshal(bytes, &p)
+	if err != nil {
+		return Plan{}, fmt.Errorf("error unmarshalling plan JSON from %s: %v", path, err)
+	}
+	if p.Version != Version {
+		return Plan{}, fmt.Errorf("plan %s has version %d, expected version %d", path, p.Version, Version)
+	}
+	for i, op := range p.Operations {
+		err = op.Check()
+		if err != nil {
+			return Plan{}, fmt.Errorf("plan %s operation %d: %v", path, i+1, err)
+		}
+	}
+	return p, nil
+}
+
+// Save writes p to path.
+func (p Plan) Save(path string) error {
+	bytes, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling plan JSON: %v", err)
+	}
+	err = state.WriteFile(path, bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing plan to %s: %v", path, err)
+	}
+	return nil
+}
+
+func base(current []omnifocus.Task) map[string]string {
+	r := map[string]string{}
+	for _, t := range current {
+		r[t.ID] = t.Fingerprint()
+	}
+	return r
+}
@@ -0,0 +1,83 @@
+package plan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/delta"
+	"github.com/mikerhodes/github-to-omnifocus/internal/gh"
+	"github.com/mikerhodes/github-to-omnifocus/internal/omnifocus"
+	"github.com/mikerhodes/github-to-omnifocus/internal/state"
+)
+
+func TestSaveLoad(t *testing.T) {
+	task := omnifocus.Task{ID: "abc", Name: "mikerhodes/github-to-omnifocus#3 foo", Kind: omnifocus.KindReview}
+	desired := omnifocus.DesiredTask{
+		Item: gh.GitHubItem{Title: "bar", K: "mikerhodes/github-to-omnifocus#4"},
+		Kind: omnifocus.KindAssigned,
+	}
+	p := New([]omnifocus.Operation{
+		{Type: delta.Add, Desired: &desired},
+		{Type: delta.Remove, Task: &task},
+	}, []omnifocus.Task{task}, state.New())
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	p2, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p2.Operations) != 2 {
+		t.Fatalf("Expected 2 operations, got %d", len(p2.Operations))
+	}
+	if p2.Operations[0].Type != delta.Add || p2.Operations[0].Desired.Key() != desired.Key() {
+		t.Fatalf("Unexpected first operation: %s", p2.Operations[0])
+	}
+	if p2.Operations[1].Type != delta.Remove || p2.Operations[1].Task.ID != "abc" {
+		t.Fatalf("Unexpected second operation: %s", p2.Operations[1])
+	}
+	if err := p2.Check([]omnifocus.Task{task}); err != nil {
+		t.Fatalf("Expected loaded plan to match its base state: %v", err)
+	}
+}
+
+func TestLoadRejectsOtherVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("Expected error loading plan with unknown version")
+	}
+}
+
+func TestLoadRejectsMalformedOperations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	plan := `{"version": 1, "operations": [{"type": "add"}]}`
+	if err := os.WriteFile(path, []byte(plan), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("Expected error loading plan with an add that has no desired task")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	a := omnifocus.Task{ID: "a"}
+	b := omnifocus.Task{ID: "b"}
+	p := New([]omnifocus.Operation{}, []omnifocus.Task{a, b}, state.New())
+
+	if err := p.Check([]omnifocus.Task{b, a}); err != nil {
+		t.Fatalf("Expected unchanged state to pass check: %v", err)
+	}
+	if err := p.Check([]omnifocus.Task{a}); err == nil {
+		t.Fatal("Expected removed task to fail check")
+	}
+	if err := p.Check([]omnifocus.Task{a, b, {ID: "c"}}); err == nil {
+		t.Fatal("Expected added task to fail check")
+	}
+}
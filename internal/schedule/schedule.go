@@ -0,0 +1,128 @@
+// Package schedule works out the due and defer dates given to new tasks.
+//
+// A Policy is written in the config as one of:
+//
+//	"none"                      no date (the same as "")
+//	"today"                     today
+//	"+2 business days"          two weekdays from today
+//	"17:00"                     today at 17:00
+//	"+1 business day at 09:00"  the next weekday at 09:00
+//
+// When no time of day is given, the caller's default is used: the end of
+// the day for due dates and its start for defer dates.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EndOfDay and StartOfDay are the default times of day for due and defer
+// dates respectively.
+const (
+	EndOfDay   = 23*time.Hour + 59*time.Minute + 59*time.Second
+	StartOfDay = time.Duration(0)
+)
+
+// Policy says when a date falls, relative to the time a task is created.
+// The zero Policy gives no date.
+type Policy struct {
+	// Set is false if the policy gives no date.
+	Set bool
+	// BusinessDays is how many weekdays after today the date falls.
+	BusinessDays int
+	// At is the time of day, if HasAt is true.
+	At    time.Duration
+	HasAt bool
+}
+
+// Parse reads a Policy written as described in the package documentation.
+func Parse(s string) (Policy, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "none" {
+		return Policy{}, nil
+	}
+
+	p := Policy{Set: true}
+	day, at, hasAt := strings.Cut(s, " at ")
+	if !hasAt && strings.Contains(s, ":") {
+		day, at, hasAt = "today", s, true
+	}
+	if hasAt {
+		d, err := parseClock(at)
+		if err != nil {
+			return Policy{}, fmt.Errorf("bad date policy %q: %v", s, err)
+		}
+		p.At, p.HasAt = d, true
+	}
+
+	if day == "today" {
+		return p, nil
+	}
+	fields := strings.Fields(day)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "+") || fields[1] != "business" ||
+		(fields[2] != "days" && fields[2] != "day") {
+		return Policy{}, fmt.Errorf("bad date policy %q: expected none, today, HH:MM or +N business days", s)
+	}
+	n, err := strconv.Atoi(fields[0][1:])
+	if err != nil || n < 0 {
+		return Policy{}, fmt.Errorf("bad date policy %q: bad number of days", s)
+	}
+	p.BusinessDays = n
+	return p, nil
+}
+
+// parseClock reads a time of day written as HH:MM.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("expected a time of day as HH:MM, got %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Date returns the date p gives for a task created at now, in now's
+// location. If p doesn't give a time of day, defaultAt is used. It returns
+// the zero Time if p gives no date.
+func (p Policy) Date(now time.Time, defaultAt time.Duration) time.Time {
+	if !p.Set {
+		return time.Time{}
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for n := p.BusinessDays; n > 0; {
+		day = day.AddDate(0, 0, 1)
+		if isBusinessDay(day) {
+			n--
+		}
+	}
+	at := defaultAt
+	if p.HasAt {
+		at = p.At
+	}
+	// Adding the clock to midnight would be out by an hour on days the
+	// clocks change, so the fields are set directly.
+	h, m, sec := int(at/time.Hour), int(at%time.Hour/time.Minute), int(at%time.Minute/time.Second)
+	return time.Date(day.Year(), day.Month(), day.Day(), h, m, sec, 0, day.Location())
+}
+
+func isBusinessDay(t time.Time) bool {
+	return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+}
+
+func (p Policy) String() string {
+	if !p.Set {
+		return "none"
+	}
+	s := "today"
+	if p.BusinessDays == 1 {
+		s = "+1 business day"
+	} else if p.BusinessDays > 1 {
+		s = fmt.Sprintf("+%d business days", p.BusinessDays)
+	}
+	if p.HasAt {
+		s += fmt.Sprintf(" at %02d:%02d", int(p.At/time.Hour), int(p.At%time.Hour/time.Minute))
+	}
+	return s
+}
@@ -0,0 +1,69 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]Policy{
+		"":                         {},
+		"none":                     {},
+		"today":                    {Set: true},
+		"+2 business days":         {Set: true, BusinessDays: 2},
+		"+1 business day":          {Set: true, BusinessDays: 1},
+		"17:00":                    {Set: true, At: 17 * time.Hour, HasAt: true},
+		"+1 business day at 09:30": {Set: true, BusinessDays: 1, At: 9*time.Hour + 30*time.Minute, HasAt: true},
+	}
+	for s, want := range cases {
+		got, err := Parse(s)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", s, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Parse(%q) = %+v, want %+v", s, got, want)
+		}
+	}
+
+	for _, s := range []string{"tomorrow", "+2 days", "+x business days", "today at 25:00"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q): expected an error", s)
+		}
+	}
+}
+
+func TestDate(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+	// A Thursday.
+	now := time.Date(2024, 3, 28, 10, 0, 0, 0, loc)
+
+	cases := []struct {
+		policy    string
+		defaultAt time.Duration
+		want      time.Time
+	}{
+		{"today", EndOfDay, time.Date(2024, 3, 28, 23, 59, 59, 0, loc)},
+		{"today", StartOfDay, time.Date(2024, 3, 28, 0, 0, 0, 0, loc)},
+		{"17:00", EndOfDay, time.Date(2024, 3, 28, 17, 0, 0, 0, loc)},
+		// Skips the weekend, which includes the clocks going forward.
+		{"+2 business days", EndOfDay, time.Date(2024, 4, 1, 23, 59, 59, 0, loc)},
+		{"+1 business day at 09:00", EndOfDay, time.Date(2024, 3, 29, 9, 0, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		p, err := Parse(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Date(now, c.defaultAt); !got.Equal(c.want) {
+			t.Errorf("%q: got %v, want %v", c.policy, got, c.want)
+		}
+	}
+
+	if got := (Policy{}).Date(now, EndOfDay); !got.IsZero() {
+		t.Errorf("Expected no date for the zero policy, got %v", got)
+	}
+}
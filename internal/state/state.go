@@ -0,0 +1,246 @@
+// Package state persists what github2omnifocus knows about previous runs,
+// allowing it to tell apart changes made in Omnifocus by the user from
+// changes made on GitHub.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mikerhodes/github-to-omnifocus/internal/delta"
+	"github.com/mikerhodes/github-to-omnifocus/internal/gh"
+)
+
+// Version is the version of the state file format written by this package.
+const Version = 1
+
+// State is saved at the end of each successful run and loaded at the
+// start of the next.
+type State struct {
+	Version int `json:"version"`
+	// Synced maps the key of each item that had a task at the end of the
+	// last run to the sync fingerprint of its task.
+	Synced map[string]string `json:"synced"`
+	// Tombstones maps the key of each item whose task the user completed
+	// in Omnifocus to the sync fingerprint of its task at the time. We don't
+	// re-add a task for the item until its fingerprint changes.
+	Tombstones map[string]string `json:"tombstones"`
+	// Absent maps the key of each item whose task we haven't yet completed,
+	// despite the item no longer being on GitHub, to how long it has been
+	// gone.
+	Absent map[string]Absence `json:"absent"`
+	// HTMLURLs caches the HTML URLs of notifications, keyed by the API URL
+	// of their latest comment, so they're only retrieved once.
+	HTMLURLs map[string]string `json:"htmlURLs,omitempty"`
+	// Notifications records the last poll of GitHub's notifications, so
+	// they're only retrieved again once they may have changed.
+	Notifications gh.NotificationPoll `json:"notifications"`
+}
+
+// Absence records for how long an item has been missing from GitHub.
+type Absence struct {
+	// Runs is the number of consecutive runs the item was missing from.
+	Runs int `json:"runs"`
+	// Since is when the item was first found to be missing.
+	Since time.Time `json:"since"`
+}
+
+// Grace is how long an item must be missing from GitHub before its task is
+// completed. The task is completed once either Runs or Period is reached;
+// a zero Grace completes tasks as soon as their item goes.
+type Grace struct {
+	Runs   int
+	Period time.Duration
+}
+
+// New returns an empty State.
+func New() State {
+	return State{
+		Version:    Version,
+		Synced:     map[string]string{},
+		Tombstones: map[string]string{},
+		Absent:     map[string]Absence{},
+		HTMLURLs:   map[string]string{},
+	}
+}
+
+// Load reads the state from path. If there is no file at path, as on the
+// first run, an empty State is returned.
+func Load(path string) (State, error) {
+	bytes, err := os.ReadFile(path) //nolint:gosec
+	if errors.Is(err, fs.ErrNotExist) {
+		return New(), nil
+	}
+	if err != nil {
+		return State{}, fmt.Errorf("error reading state from %s: %v", path, err)
+	}
+	s := New()
+	err = json.Unmarshal(bytes, &s)
+	if err != nil {
+		return State{}, fmt.Errorf("error unmarshalling state JSON from %s: %v", path, err)
+	}
+	if s.Version != Version {
+		return State{}, fmt.Errorf("state %s has version %d, expected version %d", path, s.Version, Version)
+	}
+	return s, nil
+}
+
+// Save writes s to path.
+func (s State) Save(path string) error {
+	bytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling state JSON: %v", err)
+	}
+	err = WriteFile(path, bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing state to %s: %v", path, err)
+	}
+	return nil
+}
+
+// WriteFile writes data to path like os.WriteFile, but by writing a
+// temporary file alongside it and renaming that over path. A crash part
+// way through leaves the old file rather than a truncated one.
+func WriteFile(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	// Once the file is renamed, there's nothing left to remove.
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Item is an item in the desired state. Its SyncFingerprint changes when
+// the item changes on GitHub.
+type Item interface {
+	delta.Keyed
+	SyncFingerprint() string
+}
+
+// TombstoneGrace is the least time a tombstone is kept for an item that is
+// missing from GitHub, so that an item dropping out of a search for a run
+// doesn't have its task re-created when it comes back.
+var TombstoneGrace = Grace{Runs: 3}
+
+// Tombstone carries out a three-way comparison between the items that had
+// tasks at the end of the last run, the desired items and the items that
+// currently have tasks. It returns the desired items that should have
+// tasks, and the state to save once they do.
+//
+// An item that had a task at the end of the last run, and is still desired
+// but no longer has a task, has had its task completed by the user. The
+// item is tombstoned: it is left out of the returned items until its
+// fingerprint changes, for example because it has a new comment. If the
+// user marks the task incomplete again, the tombstone is removed.
+//
+// A tombstoned item that stops being desired keeps its tombstone until it
+// has been missing for longer than grace, so it isn't re-added if it comes
+// back unchanged. After that, the item is taken to be closed, and it is
+// added again if it is reopened.
+func Tombstone[D Item, C delta.Keyed](s State, desired []D, current []C, grace Grace, now time.Time) ([]D, State) {
+	next := New()
+
+	hasTask := map[string]bool{}
+	for _, c := range current {
+		hasTask[c.Key()] = true
+	}
+
+	r := []D{}
+	isDesired := map[string]bool{}
+	for _, d := range desired {
+		k, fp := d.Key(), d.SyncFingerprint()
+		isDesired[k] = true
+		if tfp, ok := s.Tombstones[k]; ok && !hasTask[k] && tfp == fp {
+			next.Tombstones[k] = fp
+			continue
+		}
+		if sfp, ok := s.Synced[k]; ok && !hasTask[k] && sfp == fp {
+			log.Printf("Tombstone: %s was completed in Omnifocus; not re-adding until it changes on GitHub", k)
+			next.Tombstones[k] = fp
+			continue
+		}
+		next.Synced[k] = fp
+		r = append(r, d)
+	}
+
+	for k, fp := range s.Tombstones {
+		if isDesired[k] || hasTask[k] {
+			continue
+		}
+		a, ok := s.Absent[k]
+		if !ok {
+			a = Absence{Since: now}
+		}
+		a.Runs++
+		if grace.done(a, now) {
+			log.Printf("Tombstone: %s missing from GitHub for %d runs; forgetting it was completed", k, a.Runs)
+			continue
+		}
+		next.Tombstones[k] = fp
+		next.Absent[k] = a
+	}
+	return r, next
+}
+
+// ApplyGrace filters removals, the current items that are no longer desired,
+// down to those that have been missing for longer than their grace,
+// as returned by grace. GitHub's search index is eventually consistent, so
+// items sometimes go missing for a run and then come back; this avoids
+// their tasks being completed and re-created. How long the remaining
+// items have been missing is recorded in next.
+func ApplyGrace[C delta.Keyed](prev State, next *State, removals []C, grace func(C) Grace, now time.Time) []C {
+	r := []C{}
+	for _, c := range removals {
+		k := c.Key()
+		a, ok := prev.Absent[k]
+		if !ok {
+			a = Absence{Since: now}
+		}
+		a.Runs++
+
+		g := grace(c)
+		if g.done(a, now) {
+			r = append(r, c)
+			continue
+		}
+		log.Printf("Grace: %s missing from GitHub for %d runs since %s; not completing yet",
+			k, a.Runs, a.Since.Format(time.RFC3339))
+		next.Absent[k] = a
+	}
+	return r
+}
+
+// done returns true if an item missing for a is past its grace.
+func (g Grace) done(a Absence, now time.Time) bool {
+	if g.Runs == 0 && g.Period == 0 {
+		return true
+	}
+	if g.Runs > 0 && a.Runs >= g.Runs {
+		return true
+	}
+	if g.Period > 0 && now.Sub(a.Since) >= g.Period {
+		return true
+	}
+	return false
+}
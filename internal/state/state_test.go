@@ -0,0 +1,278 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type item struct {
+	key string
+	fp  string
+}
+
+func (i item) Key() string {
+	return i.key
+}
+
+func (i item) SyncFingerprint() string {
+	return i.fp
+}
+
+func keys(items []item) []string {
+	r := []string{}
+	for _, i := range items {
+		r = append(r, i.key)
+	}
+	return r
+}
+
+func TestTombstoneCompletedTask(t *testing.T) {
+	s := New()
+	s.Synced["foo"] = "1"
+	s.Synced["bar"] = "1"
+
+	// The user completed foo's task, but it's still desired.
+	desired := []item{{key: "foo", fp: "1"}, {key: "bar", fp: "1"}}
+	current := []item{{key: "bar"}}
+
+	r, next := Tombstone(s, desired, current, TombstoneGrace, time.Now())
+	if len(r) != 1 || r[0].key != "bar" {
+		t.Fatalf("Expected only bar to be desired, got %v", keys(r))
+	}
+	if next.Tombstones["foo"] != "1" {
+		t.Fatal("Expected foo to be tombstoned")
+	}
+	if _, ok := next.Synced["foo"]; ok {
+		t.Fatal("Expected foo not to be synced")
+	}
+
+	// foo stays tombstoned on the next run.
+	r, next = Tombstone(next, desired, current, TombstoneGrace, time.Now())
+	if len(r) != 1 || next.Tombstones["foo"] != "1" {
+		t.Fatalf("Expected foo to stay tombstoned, got %v", keys(r))
+	}
+
+	// Until it changes on GitHub.
+	desired[0].fp = "2"
+	r, next = Tombstone(next, desired, current, TombstoneGrace, time.Now())
+	if len(r) != 2 {
+		t.Fatalf("Expected foo to be re-added, got %v", keys(r))
+	}
+	if _, ok := next.Tombstones["foo"]; ok {
+		t.Fatal("Expected foo's tombstone to be removed")
+	}
+	if next.Synced["foo"] != "2" {
+		t.Fatal("Expected foo to be synced")
+	}
+}
+
+func TestTombstoneRemovedWhenItemGoes(t *testing.T) {
+	s := New()
+	s.Tombstones["foo"] = "1"
+	grace := Grace{Runs: 2}
+
+	// The tombstone is kept while foo might come back.
+	r, next := Tombstone(s, []item{}, []item{}, grace, time.Now())
+	if len(r) != 0 {
+		t.Fatalf("Expected nothing desired, got %v", keys(r))
+	}
+	if next.Tombstones["foo"] != "1" || next.Absent["foo"].Runs != 1 {
+		t.Fatalf("Expected foo's tombstone to be kept, got %+v", next)
+	}
+
+	// Until it has been missing for longer than grace.
+	r, next = Tombstone(next, []item{}, []item{}, grace, time.Now())
+	if len(r) != 0 {
+		t.Fatalf("Expected nothing desired, got %v", keys(r))
+	}
+	if _, ok := next.Tombstones["foo"]; ok {
+		t.Fatal("Expected foo's tombstone to be removed")
+	}
+	if _, ok := next.Absent["foo"]; ok {
+		t.Fatal("Expected foo's absence to be forgotten")
+	}
+
+	// So when the item is reopened, it's added again.
+	r, _ = Tombstone(next, []item{{key: "foo", fp: "1"}}, []item{}, grace, time.Now())
+	if len(r) != 1 {
+		t.Fatalf("Expected foo to be re-added, got %v", keys(r))
+	}
+}
+
+func TestTombstoneKeptWhenItemMissingForARun(t *testing.T) {
+	now := time.Now()
+	foo := []item{{key: "foo", fp: "1"}}
+
+	// Run 1 syncs foo.
+	r, s := Tombstone(New(), foo, []item{}, TombstoneGrace, now)
+	if len(r) != 1 {
+		t.Fatalf("Expected foo to be added, got %v", keys(r))
+	}
+
+	// Before run 2, the user completes foo's task.
+	r, s = Tombstone(s, foo, []item{}, TombstoneGrace, now)
+	if len(r) != 0 || s.Tombstones["foo"] != "1" {
+		t.Fatalf("Expected foo to be tombstoned, got %v", keys(r))
+	}
+
+	// On run 3, foo drops out of GitHub's results.
+	r, s = Tombstone(s, []item{}, []item{}, TombstoneGrace, now)
+	if len(r) != 0 || s.Tombstones["foo"] != "1" {
+		t.Fatalf("Expected foo to stay tombstoned, got %v", keys(r))
+	}
+
+	// On run 4, it's back unchanged, so it isn't re-added.
+	r, s = Tombstone(s, foo, []item{}, TombstoneGrace, now)
+	if len(r) != 0 || s.Tombstones["foo"] != "1" {
+		t.Fatalf("Expected foo to stay tombstoned, got %v", keys(r))
+	}
+	if _, ok := s.Absent["foo"]; ok {
+		t.Fatal("Expected foo's absence to be forgotten once it's back")
+	}
+}
+
+func TestTombstoneNewItemsAreAdded(t *testing.T) {
+	r, next := Tombstone(New(), []item{{key: "foo", fp: "1"}}, []item{}, TombstoneGrace, time.Now())
+	if len(r) != 1 || len(next.Tombstones) != 0 {
+		t.Fatalf("Expected foo to be added, got %v", keys(r))
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Version != Version || len(s.Synced) != 0 {
+		t.Fatalf("Expected empty state, got %+v", s)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := New()
+	s.Synced["foo"] = "1"
+	s.Tombstones["bar"] = "2"
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.Synced["foo"] != "1" || s2.Tombstones["bar"] != "2" {
+		t.Fatalf("Unexpected loaded state: %+v", s2)
+	}
+}
+
+func TestSaveReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(path, []byte("old state that is longer than the new one"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := New().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err != nil {
+		t.Fatalf("Expected saved state to load, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the state file to be left, got %v", entries)
+	}
+}
+
+func TestTombstoneRemovedWhenTaskReopened(t *testing.T) {
+	s := New()
+	s.Tombstones["foo"] = "1"
+
+	r, next := Tombstone(s, []item{{key: "foo", fp: "1"}}, []item{{key: "foo"}}, TombstoneGrace, time.Now())
+	if len(r) != 1 {
+		t.Fatalf("Expected reopened foo to be desired, got %v", keys(r))
+	}
+	if _, ok := next.Tombstones["foo"]; ok {
+		t.Fatal("Expected foo's tombstone to be removed")
+	}
+}
+
+func noGrace(item) Grace {
+	return Grace{}
+}
+
+func TestApplyGraceNone(t *testing.T) {
+	next := New()
+	r := ApplyGrace(New(), &next, []item{{key: "foo"}}, noGrace, time.Now())
+	if len(r) != 1 {
+		t.Fatalf("Expected foo to be removed straight away, got %v", keys(r))
+	}
+	if len(next.Absent) != 0 {
+		t.Fatalf("Expected no absences to be recorded, got %v", next.Absent)
+	}
+}
+
+func TestApplyGraceRuns(t *testing.T) {
+	grace := func(item) Grace { return Grace{Runs: 3} }
+	removals := []item{{key: "foo"}}
+	now := time.Now()
+
+	s := New()
+	for run := 1; run < 3; run++ {
+		next := New()
+		if r := ApplyGrace(s, &next, removals, grace, now); len(r) != 0 {
+			t.Fatalf("Expected foo not to be removed on run %d, got %v", run, keys(r))
+		}
+		if next.Absent["foo"].Runs != run {
+			t.Fatalf("Expected foo to be absent for %d runs, got %v", run, next.Absent["foo"])
+		}
+		s = next
+	}
+
+	next := New()
+	if r := ApplyGrace(s, &next, removals, grace, now); len(r) != 1 {
+		t.Fatalf("Expected foo to be removed on run 3, got %v", keys(r))
+	}
+	if _, ok := next.Absent["foo"]; ok {
+		t.Fatal("Expected foo's absence to be forgotten once removed")
+	}
+}
+
+func TestApplyGracePeriod(t *testing.T) {
+	grace := func(item) Grace { return Grace{Period: 10 * time.Minute} }
+	removals := []item{{key: "foo"}}
+	start := time.Now()
+
+	next := New()
+	if r := ApplyGrace(New(), &next, removals, grace, start); len(r) != 0 {
+		t.Fatalf("Expected foo not to be removed, got %v", keys(r))
+	}
+	s := next
+	next = New()
+	if r := ApplyGrace(s, &next, removals, grace, start.Add(9*time.Minute)); len(r) != 0 {
+		t.Fatalf("Expected foo not to be removed after 9 minutes, got %v", keys(r))
+	}
+	s = next
+	next = New()
+	if r := ApplyGrace(s, &next, removals, grace, start.Add(10*time.Minute)); len(r) != 1 {
+		t.Fatalf("Expected foo to be removed after 10 minutes, got %v", keys(r))
+	}
+}
+
+func TestApplyGraceForgottenWhenItemReturns(t *testing.T) {
+	grace := func(item) Grace { return Grace{Runs: 2} }
+	next := New()
+	ApplyGrace(New(), &next, []item{{key: "foo"}}, grace, time.Now())
+
+	// foo is back, so it isn't in removals.
+	s := next
+	next = New()
+	ApplyGrace(s, &next, []item{}, grace, time.Now())
+	if _, ok := next.Absent["foo"]; ok {
+		t.Fatal("Expected foo's absence to be forgotten")
+	}
+}